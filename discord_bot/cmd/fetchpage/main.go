@@ -0,0 +1,29 @@
+// fetchpage fetches a web page the same way links posted in Discord are
+// fetched and prints the text that would be indexed.
+//
+//	go run ./cmd/fetchpage https://example.com/post
+//
+// The fetcher's limits and private address blocking are tested in
+// internal/extract against the pages in its testdata directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/matthewgaim/intellicord/internal/extract"
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: fetchpage <url>")
+	}
+	page, err := extract.FetchWebPage(context.Background(), flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error fetching page: %v", err)
+	}
+	fmt.Printf("url:   %s\ntitle: %s\nsize:  %d bytes\n\n%s\n", page.URL, page.Title, page.Size, page.Text)
+}
@@ -11,9 +11,13 @@ import (
 
 	"github.com/matthewgaim/intellicord/internal/ai"
 	"github.com/matthewgaim/intellicord/internal/api"
+	"github.com/matthewgaim/intellicord/internal/blobs"
 	"github.com/matthewgaim/intellicord/internal/db"
 	"github.com/matthewgaim/intellicord/internal/guilds"
 	"github.com/matthewgaim/intellicord/internal/handlers"
+	"github.com/matthewgaim/intellicord/internal/history"
+	"github.com/matthewgaim/intellicord/internal/jobs"
+	"github.com/matthewgaim/intellicord/internal/reindex"
 )
 
 func main() {
@@ -31,12 +35,15 @@ func main() {
 
 	ai.InitAI()
 	db.InitDB()
+	if err := blobs.Init(); err != nil {
+		log.Fatalf("Error setting up blob storage: %v", err)
+	}
 
 	// Command handlers
 	handlers.InitCommands()
 
 	// Command lookup
-	dg.AddHandler(handlers.CommandLookupHandler())
+	dg.AddHandler(handlers.InteractionRouter())
 
 	// Register commands when bot is ready
 	dg.AddHandler(handlers.BotReadyRegisterCommandsHandler(dg))
@@ -50,15 +57,28 @@ func main() {
 	// Respond to user in a bot-created thread
 	dg.AddHandler(handlers.BotRespondToThreadHandler())
 
-	// Listen for new attachments
+	// Re-answer when the latest question in a bot-created thread is edited
+	dg.AddHandler(handlers.BotReanswerEditedQuestionHandler())
+
+	// Answer mentions from the server's knowledge base
+	dg.AddHandler(handlers.BotMentionedHandler())
+
+	// Keep documents tagged with their forum post's tags
+	dg.AddHandler(handlers.ForumPostTagsUpdatedHandler())
+
+	// Listen for new attachments and links
 	dg.AddHandler(handlers.StartThreadFromAttachmentUploadHandler())
 
 	// Listen for attachments deleted
 	dg.AddHandler(handlers.AttachmentDeletedHandler())
+	dg.AddHandler(handlers.AttachmentsBulkDeletedHandler())
 
 	// Start a thread from 'Message Reply' to a message with attachments
 	dg.AddHandler(handlers.StartThreadFromReplyHandler())
 
+	// Queue new messages of channels whose history is indexed
+	dg.AddHandler(handlers.ChannelHistoryMessageHandler())
+
 	dg.Identify.Intents = discordgo.IntentsAll
 	if err = dg.Open(); err != nil {
 		log.Fatalf("Error opening connection: %v", err)
@@ -70,6 +90,18 @@ func main() {
 		log.Fatal("Bot user is not initialized")
 	}
 
+	// Workers that download, parse and embed uploaded files
+	jobs.Start(dg)
+
+	// Resumes re-index runs interrupted by a restart
+	reindex.Start()
+
+	// Expires original files per plan and deletes unused blobs
+	blobs.StartRetention()
+
+	// Indexes new messages of channels whose history is indexed
+	history.Start(dg)
+
 	go api.InitAPI()
 
 	stop := make(chan os.Signal, 1)
@@ -2,6 +2,8 @@ package ai
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,7 +14,10 @@ import (
 	"sync"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v5"
+	"github.com/matthewgaim/intellicord/internal/blobs"
 	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/extract"
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/pgvector/pgvector-go"
@@ -20,9 +25,10 @@ import (
 )
 
 const (
-	ChunkSize     = 512
-	OverlapSize   = 128
-	SYSTEM_PROMPT = `
+	ChunkSize       = 512
+	OverlapSize     = 128
+	CHUNKER_VERSION = 1 // bump when ChunkSize, OverlapSize or SplitDocument change, so /reindex picks up old chunks
+	SYSTEM_PROMPT   = `
 	You are Intellicord, a concise and knowledgeable Discord bot. Follow these principles:
 
 	1. Tone & Clarity
@@ -52,7 +58,18 @@ const (
 		- If a request is impossible, briefly explain why.
 		- Suggest alternatives when relevant.
 	
-	6. Stay concise, clear, and helpful at all times.
+	6. Charts
+		- If the user asks for a graph or chart, reply with a short explanation and one fenced block tagged chart containing JSON:
+			{"type": "bar" | "line" | "pie", "title": "...", "labels": ["..."], "values": [1, 2]}
+		- If the data comes from an uploaded spreadsheet, you can instead reference its columns by their exact header names:
+			{"type": "bar", "title": "...", "label_column": "...", "value_column": "..."}
+		- Never draw charts with text or ASCII art.
+
+	7. Sources
+		- Context is labeled with where it came from: a page and section like (page 14, Setup > Docker), or a path and line range like pkg/foo.go:120-160.
+		- When your answer relies on it, cite the location so users can find it.
+
+	8. Stay concise, clear, and helpful at all times.
 	`
 )
 
@@ -81,22 +98,175 @@ func InitAI() {
 	)
 }
 
-func ChunkAndEmbed(ctx context.Context, message_id string, content string, title string, doc_url string, discord_server_id string, fileSize int, channelID string, uploader_id string) error {
-	_, err := db.DbPool.Exec(ctx, `
-	INSERT INTO uploaded_files (
-		discord_server_id,
-		channel_id,
-		uploader_id,
-		title,
-		file_url,
-		file_size
-	) VALUES ($1, $2, $3, $4, $5, $6)
-	`, discord_server_id, channelID, uploader_id, title, doc_url, fileSize)
+// Records the document, then chunks and embeds the extracted text. Retries of
+// the same job reuse the document instead of recording it again.
+func ChunkAndEmbed(ctx context.Context, doc db.Document, result extract.Result) error {
+	hash := sha256.Sum256([]byte(result.Text))
+	doc.ContentHash = hex.EncodeToString(hash[:])
+	doc.MimeType = result.MimeType
+	doc.FileSize = result.Size
+	doc.PageCount = result.PageCount
+	doc.Status = db.DocumentProcessing
+	doc.EmbeddingModel = embedder.Model()
+	doc.ChunkerVersion = CHUNKER_VERSION
+	doc.OriginalKey, doc.TextKey = blobs.Archive(ctx, result)
+
+	// A retried job reuses the document of its earlier attempt
+	var err error
+	doc.ID, err = db.GetJobDocument(ctx, doc.JobID)
+	if err != nil {
+		return fmt.Errorf("Error looking up the job's document: %v", err)
+	}
+	if doc.ID != 0 {
+		err = db.ResetDocument(ctx, doc.ID)
+	} else {
+		doc.ID, err = db.CreateDocument(ctx, doc)
+	}
+	if err != nil {
+		return err
+	}
+
+	status := db.DocumentReady
+	err = storeChunks(ctx, doc.ID, doc.MessageID, SplitDocument(result), doc.Title, doc.SourceURL, doc.ServerID, 0)
 	if err != nil {
-		return fmt.Errorf("Error uploading to uploaded_files: %v", err)
+		status = db.DocumentFailed
 	}
+	if statusErr := db.SetDocumentStatus(ctx, doc.ID, status); statusErr != nil {
+		log.Printf("Error updating document status: %v", statusErr)
+	}
+	return err
+}
+
+// Chunks the text and stores each chunk's embedding under message_id, without a document
+func EmbedChunks(ctx context.Context, message_id string, content string, title string, doc_url string, discord_server_id string) error {
+	return storeChunks(ctx, 0, message_id, SplitDocument(extract.Result{Text: content}), title, doc_url, discord_server_id, 0)
+}
+
+// Stores a conversation window of an indexed channel as one chunk of the
+// channel's history document, under the window's first message
+func EmbedHistoryWindow(ctx context.Context, documentID int, firstMessageID string, content string, jumpLink string, title string, discord_server_id string) error {
+	chunk := Chunk{Content: content, Section: jumpLink}
+	return storeChunks(ctx, documentID, firstMessageID, []Chunk{chunk}, title, jumpLink, discord_server_id, 0)
+}
+
+// The model new chunks are embedded with
+func EmbeddingModel() string {
+	return embedder.Model()
+}
+
+// Re-chunks and re-embeds the document into the run's hidden chunks, from its
+// stored text or else its source URL. skipped is true when neither is available.
+// History documents keep their conversation windows, they're only re-embedded.
+func ReindexDocument(ctx context.Context, runID int, doc db.Document) (skipped bool, err error) {
+	// Left over from an attempt before the run was interrupted
+	_, err = db.DbPool.Exec(ctx, `DELETE FROM chunks WHERE document_id = $1 AND reindex_run_id = $2`, doc.ID, runID)
+	if err != nil {
+		return false, err
+	}
+
+	if doc.MimeType == db.HistoryMimeType {
+		return false, reembedHistory(ctx, runID, doc)
+	}
+
+	var result extract.Result
+	if doc.TextKey != "" {
+		result, err = blobs.LoadText(ctx, doc.TextKey)
+		if err != nil {
+			log.Printf("Error loading stored text of document %d: %v", doc.ID, err)
+		}
+	}
+	if (doc.TextKey == "" || err != nil) && doc.MimeType == "text/html" && doc.OriginalKey == "" {
+		// Web pages are fetched again like a posted link, the address could have
+		// turned private since they were indexed
+		var page extract.Page
+		page, err = extract.FetchWebPage(ctx, doc.SourceURL)
+		if err != nil {
+			log.Printf("Document %d has no stored text and its page can't be fetched: %v", doc.ID, err)
+			return true, nil
+		}
+		result = extract.Result{Text: page.Text, Size: page.Size, MimeType: "text/html"}
+	} else if doc.TextKey == "" || err != nil {
+		result, err = extract.FromURL(ctx, doc.SourceURL, doc.Title)
+		if err != nil {
+			log.Printf("Document %d has no stored text and its URL can't be read: %v", doc.ID, err)
+			return true, nil
+		}
+	}
+	return false, storeChunks(ctx, doc.ID, doc.MessageID, SplitDocument(result), doc.Title, doc.SourceURL, doc.ServerID, runID)
+}
 
-	chunks := chunkText(content)
+func reembedHistory(ctx context.Context, runID int, doc db.Document) error {
+	rows, err := db.DbPool.Query(ctx, `
+		SELECT message_id, content, COALESCE(section, ''), COALESCE(doc_url, ''), COALESCE(ordinal, 0)
+		FROM chunks WHERE document_id = $1 AND reindex_run_id IS NULL
+	`, doc.ID)
+	if err != nil {
+		return err
+	}
+	type window struct {
+		messageID string
+		docURL    string
+		chunk     Chunk
+	}
+	windows, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (window, error) {
+		var w window
+		err := row.Scan(&w.messageID, &w.chunk.Content, &w.chunk.Section, &w.docURL, &w.chunk.Ordinal)
+		return w, err
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range windows {
+		if err := storeChunks(ctx, doc.ID, w.messageID, []Chunk{w.chunk}, doc.Title, w.docURL, doc.ServerID, runID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Source files are split on declarations, everything else on headings or length.
+// Chunks of a segment keep its page and heading path.
+func SplitDocument(doc extract.Result) []Chunk {
+	var chunks []Chunk
+	switch {
+	case len(doc.Files) > 0:
+		for _, f := range doc.Files {
+			chunks = append(chunks, chunkCode(f.Path, f.Content)...)
+		}
+	case len(doc.Segments) > 0:
+		for _, segment := range doc.Segments {
+			path := segment.HeadingPath
+			if segment.Sheet != "" {
+				path = append([]string{segment.Sheet}, path...)
+			}
+			for _, chunk := range chunkText(segment.Text) {
+				chunk.Page = segment.Page
+				chunk.Section = joinSection(append(slices.Clone(path), chunk.Section))
+				chunks = append(chunks, chunk)
+			}
+		}
+	default:
+		chunks = chunkText(doc.Text)
+	}
+	for i := range chunks {
+		chunks[i].Ordinal = i
+	}
+	return chunks
+}
+
+func joinSection(path []string) string {
+	var parts []string
+	for _, part := range path {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " > ")
+}
+
+// documentID 0 stores the chunks without a document. Chunks of a re-index run
+// are hidden from search until the run completes.
+func storeChunks(ctx context.Context, documentID int, message_id string, chunks []Chunk, title string, doc_url string, discord_server_id string, reindexRunID int) error {
 	embedChan := make(chan EmbedChannelObject, len(chunks))
 	errChan := make(chan error, len(chunks))
 	var wg sync.WaitGroup
@@ -112,6 +282,7 @@ func ChunkAndEmbed(ctx context.Context, message_id string, content string, title
 		close(errChan)
 	}()
 
+	stored := 0
 	for embedChan != nil || errChan != nil {
 		select {
 		case embedding, ok := <-embedChan:
@@ -119,12 +290,23 @@ func ChunkAndEmbed(ctx context.Context, message_id string, content string, title
 				embedChan = nil
 				continue
 			}
-			_, err := db.DbPool.Exec(ctx,
-				"INSERT INTO chunks (message_id, title, doc_url, content, embedding, discord_server_id) VALUES ($1, $2, $3, $4, $5, $6)",
-				message_id, title, doc_url, embedding.Chunk, pgvector.NewVector(embedding.Vector), discord_server_id)
+			chunk := embedding.Chunk
+			var sourcePath, startLine, endLine any
+			if chunk.SourcePath != "" {
+				sourcePath, startLine, endLine = chunk.SourcePath, chunk.StartLine, chunk.EndLine
+			}
+			_, err := db.DbPool.Exec(ctx, `
+				INSERT INTO chunks (
+					message_id, title, doc_url, content, embedding, discord_server_id,
+					source_path, start_line, end_line, document_id, ordinal, page, section, reindex_run_id
+				) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0), $11, NULLIF($12, 0), NULLIF($13, ''), NULLIF($14, 0))`,
+				message_id, title, doc_url, chunk.Content, pgvector.NewVector(embedding.Vector), discord_server_id,
+				sourcePath, startLine, endLine, documentID, chunk.Ordinal, chunk.Page, chunk.Section, reindexRunID)
 			if err != nil {
 				log.Printf("Error inserting chunk: %v", err)
+				continue
 			}
+			stored++
 
 		case err, ok := <-errChan:
 			if !ok {
@@ -134,35 +316,53 @@ func ChunkAndEmbed(ctx context.Context, message_id string, content string, title
 			log.Printf("Embedding error: %v", err)
 		}
 	}
+	if stored == 0 && len(chunks) > 0 {
+		return fmt.Errorf("none of the %d chunks could be stored", len(chunks))
+	}
 	return nil
 }
 
-func LlmGenerateText(history []*discordgo.Message, userMessage string, company string, botID string, model string) (string, error) {
+func LlmGenerateText(history []*discordgo.Message, userMessage string, botID string, config db.LLMConfig) (string, error) {
+	return LlmGenerateTextContext(context.Background(), history, userMessage, botID, config)
+}
+
+// LlmGenerateText that gives up when the context is done
+func LlmGenerateTextContext(ctx context.Context, history []*discordgo.Message, userMessage string, botID string, config db.LLMConfig) (string, error) {
 	response := ""
 	var err error = nil
+	company, model := config.Company, config.Model
 	if company == "openai" {
 		log.Println("Generating response with OpenAI")
-		response, err = OpenAIGenerateText(history, userMessage, botID, model)
+		response, err = OpenAIGenerateText(ctx, history, userMessage, botID, config)
 	} else if company == "google" {
 		log.Println("Generating response with Google")
-		response, err = GeminiGenerateText(history, userMessage, botID, model)
+		response, err = GeminiGenerateText(ctx, history, userMessage, botID, config)
 	} else if company == "custom" {
 		log.Printf("Generating response with custom API '%s' and model '%s'", customBaseURL, model)
-		response, err = CustomAIGenerateText(history, userMessage, botID, model)
+		response, err = CustomAIGenerateText(ctx, history, userMessage, botID, config)
 	} else {
 		log.Println("No company chosen for LLM")
 	}
 	return response, err
 }
 
-func OpenAIGenerateText(msg_history []*discordgo.Message, userMessage string, botID string, model string) (string, error) {
+func buildSystemPrompt(config db.LLMConfig) string {
+	if config.Persona == "" {
+		return SYSTEM_PROMPT
+	}
+	return fmt.Sprintf("%s\n\tPersona for this channel (follow it unless it conflicts with the rules above):\n\t%s\n", SYSTEM_PROMPT, config.Persona)
+}
+
+func OpenAIGenerateText(ctx context.Context, msg_history []*discordgo.Message, userMessage string, botID string, config db.LLMConfig) (string, error) {
 	history := discordMessagesToOpenAIMessages(msg_history, botID)
-	history = slices.Insert(history, 0, openai.SystemMessage(SYSTEM_PROMPT))
+	history = slices.Insert(history, 0, openai.SystemMessage(buildSystemPrompt(config)))
 	history = append(history, openai.UserMessage(userMessage))
-	chatCompletion, err := oai.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
+	req := openai.ChatCompletionNewParams{
 		Messages: history,
-		Model:    model,
-	})
+		Model:    config.Model,
+	}
+	applyOpenAIParams(&req, config.Params)
+	chatCompletion, err := oai.Chat.Completions.New(ctx, req)
 	if err != nil {
 		return "", err
 	}
@@ -170,12 +370,11 @@ func OpenAIGenerateText(msg_history []*discordgo.Message, userMessage string, bo
 	return response, nil
 }
 
-func GeminiGenerateText(msg_history []*discordgo.Message, userMessage string, botID string, model string) (string, error) {
+func GeminiGenerateText(ctx context.Context, msg_history []*discordgo.Message, userMessage string, botID string, config db.LLMConfig) (string, error) {
 	history := discordMessagesToGeminiMessages(msg_history, botID)
-	history = slices.Insert(history, 0, genai.NewContentFromText(SYSTEM_PROMPT, genai.RoleModel))
+	history = slices.Insert(history, 0, genai.NewContentFromText(buildSystemPrompt(config), genai.RoleModel))
 
-	ctx := context.Background()
-	chat, err := gai.Chats.Create(ctx, model, nil, history)
+	chat, err := gai.Chats.Create(ctx, config.Model, geminiGenerateConfig(config.Model, config.Params), history)
 	if err != nil {
 		return "", err
 	}
@@ -191,19 +390,21 @@ func GeminiGenerateText(msg_history []*discordgo.Message, userMessage string, bo
 	}
 }
 
-func CustomAIGenerateText(msg_history []*discordgo.Message, userMessage string, botID string, model string) (string, error) {
+func CustomAIGenerateText(ctx context.Context, msg_history []*discordgo.Message, userMessage string, botID string, config db.LLMConfig) (string, error) {
 	if customBaseURL == "" {
 		return "", fmt.Errorf("CUSTOM_BASE_URL is required")
 	}
 
 	history := discordMessagesToOpenAIMessages(msg_history, botID)
-	history = slices.Insert(history, 0, openai.SystemMessage(SYSTEM_PROMPT))
+	history = slices.Insert(history, 0, openai.SystemMessage(buildSystemPrompt(config)))
 	history = append(history, openai.UserMessage(userMessage))
 
-	chatCompletion, err := cai.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-		Model:    model,
+	req := openai.ChatCompletionNewParams{
+		Model:    config.Model,
 		Messages: history,
-	})
+	}
+	applyCustomParams(&req, config.Params)
+	chatCompletion, err := cai.Chat.Completions.New(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("custom LLM request failed: %w", err)
 	}
@@ -215,108 +416,150 @@ func CustomAIGenerateText(msg_history []*discordgo.Message, userMessage string,
 	return chatCompletion.Choices[0].Message.Content, nil
 }
 
-func QueryVectorDB(ctx context.Context, query string, rootMsgID string, numOfAttachments int) string {
-	var embedChan = make(chan EmbedChannelObject)
-	var errChan = make(chan error)
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go newEmbedding(query, embedChan, errChan, &wg)
+func QueryVectorDB(ctx context.Context, query string, messageIDs []string, limit int) string {
+	chunks, err := SearchChunks(ctx, query, messageIDs, limit)
+	if err != nil {
+		log.Println("Error searching chunks:", err)
+		return ""
+	}
+	return FormatChunks(chunks)
+}
 
-	go func() {
-		wg.Wait()
-		close(embedChan)
-		close(errChan)
-	}()
-	var queryVector []float32
-	for {
-		select {
-		case embedding, ok := <-embedChan:
-			if !ok {
-				embedChan = nil
-			} else {
-				queryVector = embedding.Vector
-			}
-		case err, ok := <-errChan:
-			if !ok {
-				errChan = nil
-			} else {
-				log.Printf("Embedding error: %v", err)
-				return ""
-			}
+// The chunks as context for the LLM, each labeled with where it came from
+func FormatChunks(chunks []RetrievedChunk) string {
+	var context []string
+	for _, chunk := range chunks {
+		log.Printf("Relevant chunk (#%d) Distance: %f", chunk.ID, chunk.Distance)
+		title := chunk.Title
+		if len(chunk.Tags) > 0 {
+			title = fmt.Sprintf("%s [%s]", title, strings.Join(chunk.Tags, ", "))
 		}
-
-		if embedChan == nil && errChan == nil {
-			break
+		if location := chunk.Location(); location != "" {
+			context = append(context, fmt.Sprintf("%s (%s):\n%s", title, location, chunk.Content))
+			continue
 		}
+		context = append(context, fmt.Sprintf("%s: %s", title, chunk.Content))
+	}
+	result := strings.Join(context, "\n")
+	return result
+}
+
+type RetrievedChunk struct {
+	Chunk
+	ID        int
+	MessageID string
+	Title     string
+	Tags      []string // of the chunk's document
+	Distance  float32
+}
+
+// Nearest chunks to the query among the messages' documents, closest first
+func SearchChunks(ctx context.Context, query string, messageIDs []string, limit int) ([]RetrievedChunk, error) {
+	return searchChunks(ctx, query, "message_id = ANY($2)", messageIDs, limit)
+}
+
+// Nearest chunks to the query among the server's knowledge base, closest first
+func SearchKnowledgeBase(ctx context.Context, query string, serverID string, limit int) ([]RetrievedChunk, error) {
+	return searchChunks(ctx, query, "document_id IN (SELECT document_id FROM knowledge_base WHERE discord_server_id = $2)", serverID, limit)
+}
+
+// filter is the WHERE clause, with arg as $2
+func searchChunks(ctx context.Context, query string, filter string, arg any, limit int) ([]RetrievedChunk, error) {
+	queryVector, err := embedder.Embed(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("embedding error: %w", err)
 	}
 
 	rows, err := db.DbPool.Query(ctx, `
-		SELECT id, content, title, embedding <-> $1 AS distance 
-		FROM chunks 
-		WHERE message_id = $2
-		ORDER BY distance 
-		LIMIT $3`, pgvector.NewVector(queryVector), rootMsgID, numOfAttachments+1)
+		SELECT c.id, c.message_id, c.content, c.title, COALESCE(c.source_path, ''), COALESCE(c.start_line, 0), COALESCE(c.end_line, 0),
+			COALESCE(c.page, 0), COALESCE(c.section, ''), COALESCE(d.tags, '{}'), c.embedding <-> $1 AS distance
+		FROM chunks c
+		LEFT JOIN documents d ON d.id = c.document_id
+		WHERE c.reindex_run_id IS NULL AND c.`+filter+`
+		ORDER BY distance
+		LIMIT $3`, pgvector.NewVector(queryVector), arg, limit)
 	if err != nil {
-		log.Println("Error querying nearest neighbors:", err)
-		return ""
+		return nil, fmt.Errorf("error querying nearest neighbors: %w", err)
 	}
 	defer rows.Close()
-	var context []string
+	var chunks []RetrievedChunk
 	for rows.Next() {
-		var content string
-		var title string
-		var distance float32
-		var id int
-		err := rows.Scan(&id, &content, &title, &distance)
+		var chunk RetrievedChunk
+		err := rows.Scan(&chunk.ID, &chunk.MessageID, &chunk.Content, &chunk.Title, &chunk.SourcePath, &chunk.StartLine, &chunk.EndLine, &chunk.Page, &chunk.Section, &chunk.Tags, &chunk.Distance)
 		if err != nil {
-			log.Println("Error scanning row:", err)
-			return ""
+			return nil, fmt.Errorf("error scanning row: %w", err)
 		}
-		content = fmt.Sprintf("%s: %s", title, content)
-		context = append(context, content)
-		fmt.Printf("Relevant chunk (#%d) Distance: %f\n", id, distance)
+		chunks = append(chunks, chunk)
 	}
-	result := strings.Join(context, "\n")
-	return result
+	return chunks, rows.Err()
 }
 
 type EmbedChannelObject struct {
-	Chunk  string
+	Chunk  Chunk
 	Vector []float32
 }
 
-func newEmbedding(text string, embedChannel chan EmbedChannelObject, errChannel chan error, wg *sync.WaitGroup) {
+func newEmbedding(chunk Chunk, embedChannel chan EmbedChannelObject, errChannel chan error, wg *sync.WaitGroup) {
 	defer wg.Done()
-	embeddingInput := openai.EmbeddingNewParamsInputUnion{
-		OfArrayOfStrings: []string{text},
+	// The path helps match questions that name a file
+	text := chunk.Content
+	if chunk.SourcePath != "" {
+		text = chunk.SourcePath + "\n" + text
 	}
-	res, err := oai.Embeddings.New(context.Background(), openai.EmbeddingNewParams{
-		Input: embeddingInput,
-		Model: openai.EmbeddingModelTextEmbedding3Small,
-	})
+	vector, err := embedder.Embed(context.Background(), text)
 	if err != nil {
 		log.Printf("Error embedding text: %v", err)
 		errChannel <- err
 	} else {
-		// float64 to 32 conversion for pgvector
-		var embedding32 []float32
-		for _, ve := range res.Data[0].Embedding {
-			embedding32 = append(embedding32, float32(ve))
-		}
-		embedChannel <- EmbedChannelObject{Chunk: text, Vector: embedding32}
+		embedChannel <- EmbedChannelObject{Chunk: chunk, Vector: vector}
 	}
 }
 
-func chunkText(text string) []string {
-	var chunks []string
+// A chunk of a document, source code chunks also know where they came from
+type Chunk struct {
+	Content    string
+	Ordinal    int    // position in the document
+	Page       int    // 0 when the format has no pages
+	Section    string // heading path, like "Setup > Docker"
+	SourcePath string
+	StartLine  int // 1-based, inclusive
+	EndLine    int
+}
 
-	headingRegex := regexp.MustCompile(`(?m)^#.*`)
-	sections := headingRegex.Split(text, -1)
+// Where the chunk is in its document, like "page 14, Setup > Docker" or "pkg/foo.go:120-160"
+func (c Chunk) Location() string {
+	if c.SourcePath != "" {
+		return fmt.Sprintf("%s:%d-%d", c.SourcePath, c.StartLine, c.EndLine)
+	}
+	var parts []string
+	if c.Page > 0 {
+		parts = append(parts, fmt.Sprintf("page %d", c.Page))
+	}
+	if c.Section != "" {
+		parts = append(parts, c.Section)
+	}
+	return strings.Join(parts, ", ")
+}
 
-	if len(sections) > 1 { // Chunk by headings
-		for _, section := range sections {
-			chunks = append(chunks, section)
+var headingRegex = regexp.MustCompile(`(?m)^#.*`)
+
+func chunkText(text string) []Chunk {
+	var chunks []Chunk
+
+	headings := headingRegex.FindAllStringIndex(text, -1)
+	if len(headings) > 0 { // Chunk by headings
+		chunks = appendTextChunk(chunks, text[:headings[0][0]], "")
+		var path []string // heading path, one entry per level
+		for i, h := range headings {
+			heading := text[h[0]:h[1]]
+			level := len(heading) - len(strings.TrimLeft(heading, "#"))
+			path = append(path[:min(level-1, len(path))], strings.TrimSpace(heading[level:]))
+
+			end := len(text)
+			if i+1 < len(headings) {
+				end = headings[i+1][0]
+			}
+			chunks = appendTextChunk(chunks, text[h[1]:end], strings.Join(path, " > "))
 		}
 	} else { // Chunk by fixed length
 		words := strings.Fields(text)
@@ -325,13 +568,22 @@ func chunkText(text string) []string {
 			if end > len(words) {
 				end = len(words)
 			}
-			chunks = append(chunks, strings.Join(words[i:end], " "))
+			chunks = append(chunks, Chunk{Content: strings.Join(words[i:end], " ")})
 		}
 	}
 	return chunks
 }
 
+func appendTextChunk(chunks []Chunk, content string, section string) []Chunk {
+	if strings.TrimSpace(content) == "" {
+		return chunks
+	}
+	return append(chunks, Chunk{Content: content, Section: section})
+}
+
 func DeleteEmbeddings(ctx context.Context, message_id string) {
+	// Chunks of a document go with it, chunks stored before documents existed don't have one
+	db.DbPool.Exec(ctx, "DELETE FROM documents WHERE message_id = $1", message_id)
 	db.DbPool.Exec(ctx, "DELETE FROM chunks WHERE message_id = $1", message_id)
 	log.Printf("Deleted chunks from message: %s", message_id)
 }
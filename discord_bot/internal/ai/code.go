@@ -0,0 +1,96 @@
+package ai
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	CODE_CHUNK_TARGET_LINES = 60  // small declarations are merged up to this
+	CODE_CHUNK_MAX_LINES    = 150 // longer declarations are split
+)
+
+// Lines that start a top-level declaration, by file extension
+var declarationPatterns = map[string]*regexp.Regexp{
+	".go":    regexp.MustCompile(`^(func|type|var|const)\b`),
+	".py":    regexp.MustCompile(`^(def|async def|class)\b`),
+	".js":    regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function|class|const|let|var)\b`),
+	".ts":    regexp.MustCompile(`^(export\s+)?(default\s+)?(declare\s+)?(abstract\s+)?(async\s+)?(function|class|const|let|var|interface|type|enum|namespace)\b`),
+	".rs":    regexp.MustCompile(`^(pub(\([^)]*\))?\s+)?(async\s+)?(unsafe\s+)?(fn|struct|enum|trait|impl|mod|type|const|static|macro_rules!)\b`),
+	".rb":    regexp.MustCompile(`^(def|class|module)\b`),
+	".php":   regexp.MustCompile(`^(abstract\s+|final\s+)?(function|class|interface|trait|enum)\b`),
+	".swift": regexp.MustCompile(`^(public\s+|private\s+|internal\s+|open\s+)?(final\s+)?(func|class|struct|enum|protocol|extension)\b`),
+	".kt":    regexp.MustCompile(`^(public\s+|private\s+|internal\s+)?(data\s+|sealed\s+|abstract\s+|open\s+)?(fun|class|object|interface)\b`),
+	".sql":   regexp.MustCompile(`(?i)^(create|alter|insert|select|with|drop)\b`),
+}
+
+func init() {
+	declarationPatterns[".jsx"] = declarationPatterns[".js"]
+	declarationPatterns[".tsx"] = declarationPatterns[".ts"]
+}
+
+// Splits a source file on top-level declarations, keeping the comments and
+// decorators right above each declaration with it. Languages without a
+// pattern are split on unindented lines that follow a blank line.
+func chunkCode(filePath string, content string) []Chunk {
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	pattern := declarationPatterns[strings.ToLower(path.Ext(filePath))]
+
+	// Line indexes where a new block starts
+	starts := []int{0}
+	for i := 1; i < len(lines); i++ {
+		if !isBlockStart(lines, i, pattern) {
+			continue
+		}
+		start := i
+		for start > 0 && isLeadingComment(lines[start-1]) {
+			start--
+		}
+		if start > starts[len(starts)-1] {
+			starts = append(starts, start)
+		}
+	}
+	starts = append(starts, len(lines))
+
+	var chunks []Chunk
+	chunkStart := 0
+	for b := 1; b < len(starts); b++ {
+		end := starts[b]
+		// Merge small blocks, but never past the target size
+		if b < len(starts)-1 && starts[b+1]-chunkStart <= CODE_CHUNK_TARGET_LINES {
+			continue
+		}
+		for from := chunkStart; from < end; from += CODE_CHUNK_MAX_LINES {
+			to := min(from+CODE_CHUNK_MAX_LINES, end)
+			text := strings.Join(lines[from:to], "\n")
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			chunks = append(chunks, Chunk{Content: text, SourcePath: filePath, StartLine: from + 1, EndLine: to})
+		}
+		chunkStart = end
+	}
+	return chunks
+}
+
+func isBlockStart(lines []string, i int, pattern *regexp.Regexp) bool {
+	line := lines[i]
+	if line == "" || line[0] == ' ' || line[0] == '\t' {
+		return false
+	}
+	if pattern != nil {
+		return pattern.MatchString(line)
+	}
+	return strings.TrimSpace(lines[i-1]) == "" && !isLeadingComment(line) && !strings.HasPrefix(line, "}")
+}
+
+func isLeadingComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	for _, prefix := range []string{"//", "#", "/*", "*", "@", "--", "///"} {
+		if strings.HasPrefix(trimmed, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package ai
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const EMBEDDING_DIMENSIONS = 1536 // matches vector(1536) in the chunks table
+
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+	Model() string // stored on documents so old embeddings can be found after a model change
+}
+
+var embedder Embedder = OpenAIEmbedder{}
+
+// Swaps the embedder used for chunks and queries (the eval tool uses HashEmbedder)
+func SetEmbedder(e Embedder) {
+	embedder = e
+}
+
+type OpenAIEmbedder struct{}
+
+func (OpenAIEmbedder) Model() string {
+	return string(openai.EmbeddingModelTextEmbedding3Small)
+}
+
+func (OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	embeddingInput := openai.EmbeddingNewParamsInputUnion{
+		OfArrayOfStrings: []string{text},
+	}
+	res, err := oai.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: embeddingInput,
+		Model: openai.EmbeddingModelTextEmbedding3Small,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// float64 to 32 conversion for pgvector
+	var embedding32 []float32
+	for _, ve := range res.Data[0].Embedding {
+		embedding32 = append(embedding32, float32(ve))
+	}
+	return embedding32, nil
+}
+
+// Deterministic, offline embedder: a normalized bag of hashed words.
+// Only useful for comparing retrieval changes, not for real answers.
+type HashEmbedder struct{}
+
+func (HashEmbedder) Model() string {
+	return "hash"
+}
+
+func (HashEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vector := make([]float32, EMBEDDING_DIMENSIONS)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		sum := h.Sum32()
+		// the top bit decides the sign so collisions cancel out instead of piling up
+		sign := float32(1)
+		if sum&(1<<31) != 0 {
+			sign = -1
+		}
+		vector[sum%EMBEDDING_DIMENSIONS] += sign
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v * v)
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vector {
+			vector[i] = float32(float64(vector[i]) / norm)
+		}
+	}
+	return vector, nil
+}
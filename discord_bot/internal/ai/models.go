@@ -0,0 +1,142 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewgaim/intellicord/internal/db"
+)
+
+const (
+	MODEL_CATALOG_TTL  = 10 * time.Minute
+	TEST_PROMPT        = "Reply with the word OK."
+	VALIDATION_TIMEOUT = 30 * time.Second
+)
+
+type modelCatalog struct {
+	models    []string
+	fetchedAt time.Time
+}
+
+var (
+	catalogMu sync.Mutex
+	catalogs  = make(map[string]modelCatalog)
+)
+
+// Model IDs the provider currently serves, cached for MODEL_CATALOG_TTL
+func ListModels(ctx context.Context, company string) ([]string, error) {
+	catalogMu.Lock()
+	catalog, ok := catalogs[company]
+	catalogMu.Unlock()
+	if ok && time.Since(catalog.fetchedAt) < MODEL_CATALOG_TTL {
+		return catalog.models, nil
+	}
+
+	var models []string
+	var err error
+	switch company {
+	case "openai":
+		models, err = listOpenAIModels(ctx)
+	case "google":
+		models, err = listGeminiModels(ctx)
+	case "custom":
+		models, err = listCustomModels(ctx)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider '%s'", company)
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(models)
+
+	catalogMu.Lock()
+	catalogs[company] = modelCatalog{models: models, fetchedAt: time.Now()}
+	catalogMu.Unlock()
+	return models, nil
+}
+
+// Checks the model exists in the provider's catalog, then sends it a test prompt
+func ValidateModel(ctx context.Context, company string, model string) error {
+	ctx, cancel := context.WithTimeout(ctx, VALIDATION_TIMEOUT)
+	defer cancel()
+
+	models, err := ListModels(ctx, company)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch the %s model list: %w", company, err)
+	}
+	if !slices.Contains(models, model) {
+		return fmt.Errorf("model '%s' isn't available from %s", model, company)
+	}
+
+	config := db.LLMConfig{Company: company, Model: model}
+	response, err := LlmGenerateTextContext(ctx, nil, TEST_PROMPT, "", config)
+	if err != nil {
+		return fmt.Errorf("test prompt failed: %w", err)
+	}
+	if strings.TrimSpace(response) == "" {
+		return fmt.Errorf("test prompt returned an empty response")
+	}
+	return nil
+}
+
+func listOpenAIModels(ctx context.Context) ([]string, error) {
+	var models []string
+	iter := oai.Models.ListAutoPaging(ctx)
+	for iter.Next() {
+		id := iter.Current().ID
+		if isOpenAIChatModel(id) {
+			models = append(models, id)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// The OpenAI list includes embedding, audio and image models too
+func isOpenAIChatModel(id string) bool {
+	for _, prefix := range []string{"gpt-", "o1", "o3", "o4", "chatgpt-"} {
+		if strings.HasPrefix(id, prefix) {
+			return !strings.Contains(id, "audio") && !strings.Contains(id, "realtime") &&
+				!strings.Contains(id, "transcribe") && !strings.Contains(id, "tts") && !strings.Contains(id, "image")
+		}
+	}
+	return false
+}
+
+func listGeminiModels(ctx context.Context) ([]string, error) {
+	if gai == nil {
+		return nil, fmt.Errorf("Google AI client isn't configured")
+	}
+	var models []string
+	for model, err := range gai.Models.All(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(model.SupportedActions, "generateContent") {
+			continue
+		}
+		models = append(models, strings.TrimPrefix(model.Name, "models/"))
+	}
+	return models, nil
+}
+
+func listCustomModels(ctx context.Context) ([]string, error) {
+	if customBaseURL == "" {
+		return nil, fmt.Errorf("CUSTOM_BASE_URL is required")
+	}
+	var models []string
+	iter := cai.Models.ListAutoPaging(ctx)
+	for iter.Next() {
+		models = append(models, iter.Current().ID)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"strings"
+
+	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/shared"
+	"google.golang.org/genai"
+)
+
+// Gemini uses a thinking token budget instead of an effort level
+var geminiThinkingBudgets = map[string]int32{
+	"minimal": 0,
+	"low":     1024,
+	"medium":  8192,
+	"high":    24576,
+}
+
+// Reasoning models reject temperature and top_p
+func isOpenAIReasoningModel(model string) bool {
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5"} {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func applyOpenAIParams(req *openai.ChatCompletionNewParams, p db.GenerationParams) {
+	if p.MaxTokens != nil {
+		req.MaxCompletionTokens = openai.Int(int64(*p.MaxTokens))
+	}
+	if isOpenAIReasoningModel(req.Model) {
+		if p.ReasoningEffort != nil {
+			req.ReasoningEffort = shared.ReasoningEffort(*p.ReasoningEffort)
+		}
+		return
+	}
+	if p.Temperature != nil {
+		req.Temperature = openai.Float(*p.Temperature)
+	}
+	if p.TopP != nil {
+		req.TopP = openai.Float(*p.TopP)
+	}
+}
+
+// OpenAI-compatible servers mostly only understand the older max_tokens field
+func applyCustomParams(req *openai.ChatCompletionNewParams, p db.GenerationParams) {
+	if p.MaxTokens != nil {
+		req.MaxTokens = openai.Int(int64(*p.MaxTokens))
+	}
+	if p.Temperature != nil {
+		req.Temperature = openai.Float(*p.Temperature)
+	}
+	if p.TopP != nil {
+		req.TopP = openai.Float(*p.TopP)
+	}
+	if p.ReasoningEffort != nil {
+		req.ReasoningEffort = shared.ReasoningEffort(*p.ReasoningEffort)
+	}
+}
+
+func geminiGenerateConfig(model string, p db.GenerationParams) *genai.GenerateContentConfig {
+	config := &genai.GenerateContentConfig{}
+	if p.MaxTokens != nil {
+		config.MaxOutputTokens = int32(*p.MaxTokens)
+	}
+	if p.Temperature != nil {
+		config.Temperature = genai.Ptr(float32(*p.Temperature))
+	}
+	if p.TopP != nil {
+		config.TopP = genai.Ptr(float32(*p.TopP))
+	}
+	if p.ReasoningEffort != nil && (strings.Contains(model, "2.5") || strings.HasPrefix(model, "gemini-3")) {
+		budget := geminiThinkingBudgets[*p.ReasoningEffort]
+		// Pro models can't turn thinking off
+		if budget == 0 && strings.Contains(model, "pro") {
+			budget = 128
+		}
+		config.ThinkingConfig = &genai.ThinkingConfig{ThinkingBudget: genai.Ptr(budget)}
+	}
+	return config
+}
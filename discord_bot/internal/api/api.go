@@ -100,6 +100,8 @@ func InitAPI() {
 		protectedRoutes.GET("/analytics/files-all-servers", getFilesFromAllServers())
 		protectedRoutes.POST("/update-allowed-channels", updateAllowedChannels())
 		protectedRoutes.GET("/get-allowed-channels", getAllowedChannels())
+		protectedRoutes.GET("/get-llm-params", getLLMParams())
+		protectedRoutes.POST("/update-llm-params", updateLLMParams())
 	}
 
 	log.Println("Starting API on port 8080")
@@ -414,3 +416,94 @@ func getAllowedChannels() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"allowed_channels": allowedChannels, "server_info": server_info})
 	}
 }
+
+/*
+Returns the server's generation parameters (null means provider default)
+
+Success:
+
+	{"params": db.GenerationParams}
+
+Error:
+
+	{"error": string}
+*/
+func getLLMParams() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User ID not found"})
+			return
+		}
+		user_id := userID.(string)
+		if user_id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing user ID"})
+			return
+		}
+
+		server_id := c.Query("server_id")
+		if server_id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No server_id"})
+			return
+		}
+		ownerID, err := db.GetServerOwnerID(server_id)
+		if err != nil || user_id != ownerID {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		params, err := db.GetServerGenerationParams(server_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"params": params})
+	}
+}
+
+/*
+Updates the server's generation parameters (temperature, max tokens, top p, reasoning effort)
+
+Success:
+
+	{"message": string}
+
+Error:
+
+	{"error": string}
+*/
+func updateLLMParams() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User ID not found"})
+			return
+		}
+		user_id := userID.(string)
+		if user_id == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing user ID"})
+			return
+		}
+
+		var requestBody UpdateLLMParamsRequest
+		if err := c.ShouldBindJSON(&requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ownerID, err := db.GetServerOwnerID(requestBody.ServerID)
+		if err != nil || user_id != requestBody.UserID || user_id != ownerID {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if err := requestBody.Params.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.UpdateServerGenerationParams(requestBody.ServerID, requestBody.Params); err != nil {
+			log.Printf("Error updating generation params: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "ok"})
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "github.com/matthewgaim/intellicord/internal/db"
+
 type MiddlewareDiscordUser struct {
 	ID string `json:"id"`
 }
@@ -15,6 +17,12 @@ type UpdateAllowedChannelsRequest struct {
 	UserID     string   `json:"user_id"`
 }
 
+type UpdateLLMParamsRequest struct {
+	ServerID string              `json:"server_id"`
+	UserID   string              `json:"user_id"`
+	Params   db.GenerationParams `json:"params"`
+}
+
 type CheckoutSessionType struct {
 	Status string `json:"status"`
 	Name   string `json:"name"`
@@ -0,0 +1,125 @@
+package blobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/extract"
+)
+
+const (
+	ORIGINALS_PREFIX = "originals/"
+	TEXT_PREFIX      = "text/"
+	SWEEP_INTERVAL   = time.Hour
+	SWEEP_GRACE      = 24 * time.Hour // blobs are stored before their document is created
+)
+
+// The blob records kept in Postgres, replaced in tests
+var (
+	recordBlob           = db.RecordBlob
+	expireOriginals      = db.ExpireOriginals
+	getUnreferencedBlobs = db.GetUnreferencedBlobs
+	deleteBlobRecord     = db.DeleteBlobRecord
+)
+
+// Stores the original file and its extracted text, so the document can be
+// re-chunked and re-embedded after the Discord URL expires. Keys are empty
+// when blob storage is off or storing failed, ingestion goes on without them.
+func Archive(ctx context.Context, result extract.Result) (originalKey string, textKey string) {
+	if store == nil {
+		return "", ""
+	}
+	if len(result.Data) > 0 {
+		originalKey = ORIGINALS_PREFIX + sha256Hex(result.Data)
+		if err := put(ctx, originalKey, result.Data); err != nil {
+			log.Printf("Error storing original file: %v", err)
+			originalKey = ""
+		}
+	}
+
+	text, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Error encoding extracted text: %v", err)
+		return originalKey, ""
+	}
+	textKey = TEXT_PREFIX + sha256Hex([]byte(result.Text)) + ".json"
+	if err := put(ctx, textKey, text); err != nil {
+		log.Printf("Error storing extracted text: %v", err)
+		textKey = ""
+	}
+	return originalKey, textKey
+}
+
+func put(ctx context.Context, key string, data []byte) error {
+	if err := recordBlob(ctx, key, len(data)); err != nil {
+		return fmt.Errorf("failed to record blob %s: %v", key, err)
+	}
+	return store.Put(ctx, key, data)
+}
+
+// The extracted text stored by Archive, with its pages, sections and files
+func LoadText(ctx context.Context, key string) (extract.Result, error) {
+	if store == nil {
+		return extract.Result{}, fmt.Errorf("blob storage is off")
+	}
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return extract.Result{}, err
+	}
+	var result extract.Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return extract.Result{}, fmt.Errorf("failed to decode extracted text %s: %v", key, err)
+	}
+	return result, nil
+}
+
+// The original file stored by Archive, ErrNotFound once it expired
+func LoadOriginal(ctx context.Context, key string) ([]byte, error) {
+	if store == nil {
+		return nil, fmt.Errorf("blob storage is off")
+	}
+	return store.Get(ctx, key)
+}
+
+// Periodically expires originals past their plan's retention and deletes
+// blobs no document uses anymore
+func StartRetention() {
+	if store == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(SWEEP_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweep(context.Background())
+		}
+	}()
+}
+
+func sweep(ctx context.Context) {
+	expired, err := expireOriginals(ctx)
+	if err != nil {
+		log.Printf("Error expiring original files: %v", err)
+	} else if expired > 0 {
+		log.Printf("Expired the original files of %d documents", expired)
+	}
+
+	keys, err := getUnreferencedBlobs(ctx, time.Now().Add(-SWEEP_GRACE))
+	if err != nil {
+		log.Printf("Error listing unused blobs: %v", err)
+		return
+	}
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("Error deleting blob %s: %v", key, err)
+			continue
+		}
+		if err := deleteBlobRecord(ctx, key); err != nil {
+			log.Printf("Error deleting blob record %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthewgaim/intellicord/internal/extract"
+)
+
+// An S3 bucket in memory that checks every request's signature
+type fakeBucket struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	accessKey string
+	secretKey string
+	region    string
+}
+
+func (f *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := f.verify(r, body); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = body
+	case http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Recomputes the signature from the request as it arrived
+func (f *fakeBucket) verify(r *http.Request, body []byte) error {
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if payloadHash != sha256Hex(body) {
+		return fmt.Errorf("payload hash doesn't match the body")
+	}
+	amzDate := r.Header.Get("x-amz-date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return fmt.Errorf("invalid x-amz-date %q", amzDate)
+	}
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:8], f.region)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		"host:" + r.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+f.secretKey), amzDate[:8])
+	key = hmacSHA256(key, f.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	want := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		f.accessKey, scope, hex.EncodeToString(hmacSHA256(key, stringToSign)))
+	if got := r.Header.Get("Authorization"); got != want {
+		return fmt.Errorf("SignatureDoesNotMatch: got %q", got)
+	}
+	return nil
+}
+
+func fakeS3(t *testing.T) (*fakeBucket, *S3Store) {
+	bucket := &fakeBucket{objects: map[string][]byte{}, accessKey: "access", secretKey: "secret", region: "eu-west-1"}
+	server := httptest.NewServer(bucket)
+	t.Cleanup(server.Close)
+	s3, err := NewS3Store(server.URL, "bucket", "eu-west-1", "access", "secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return bucket, s3
+}
+
+func TestS3Store(t *testing.T) {
+	ctx := context.Background()
+	bucket, s3 := fakeS3(t)
+
+	if err := s3.Put(ctx, "text/a b.json", []byte("hello")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if got := string(bucket.objects["text/a b.json"]); got != "hello" {
+		t.Errorf("bucket holds %q, expected %q", got, "hello")
+	}
+	data, err := s3.Get(ctx, "text/a b.json")
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Get returned %q, %v", data, err)
+	}
+
+	if err := s3.Delete(ctx, "text/a b.json"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := s3.Get(ctx, "text/a b.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected error '%v', got '%v'", ErrNotFound, err)
+	}
+	if err := s3.Delete(ctx, "missing"); err != nil {
+		t.Errorf("deleting a missing blob failed: %v", err)
+	}
+
+	s3.secretKey = "wrong"
+	if err := s3.Put(ctx, "key", []byte("data")); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected a 403 error for a bad signature, got '%v'", err)
+	}
+}
+
+func TestNewS3Store(t *testing.T) {
+	if _, err := NewS3Store("", "bucket", "", "access", "secret"); err == nil {
+		t.Error("expected an error without an endpoint")
+	}
+	if _, err := NewS3Store("http://s3.example.com", "bucket", "", "", "secret"); err == nil {
+		t.Error("expected an error without an access key")
+	}
+	s3, err := NewS3Store("http://s3.example.com", "bucket", "", "access", "secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s3.region != "us-east-1" {
+		t.Errorf("region is %s, expected us-east-1", s3.region)
+	}
+}
+
+// Checked against a signature computed outside this package
+func TestSign(t *testing.T) {
+	s3, err := NewS3Store("http://s3.example.com:9000", "bucket", "eu-west-1", "access", "secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPut, "http://s3.example.com:9000/bucket/text/a%20b.json", nil)
+	s3.sign(req, []byte("hello"), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=access/20240102/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+		"Signature=5d27fca7867909cc62c6e366c7e0ba427a1bc5dca189738ab84b77574cafb50e"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization is\n%s\nexpected\n%s", got, want)
+	}
+	if got := req.Header.Get("x-amz-date"); got != "20240102T030405Z" {
+		t.Errorf("x-amz-date is %s", got)
+	}
+	if got := req.Header.Get("x-amz-content-sha256"); got != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("x-amz-content-sha256 is %s", got)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	local := LocalStore{Dir: filepath.Join(dir, "blobs")}
+
+	if err := local.Put(ctx, "originals/abc", []byte("data")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	data, err := local.Get(ctx, "originals/abc")
+	if err != nil || string(data) != "data" {
+		t.Errorf("Get returned %q, %v", data, err)
+	}
+	if err := local.Delete(ctx, "originals/abc"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := local.Get(ctx, "originals/abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected error '%v', got '%v'", ErrNotFound, err)
+	}
+	if err := local.Delete(ctx, "originals/abc"); err != nil {
+		t.Errorf("deleting a missing blob failed: %v", err)
+	}
+
+	for _, key := range []string{"../escape", "text/../../escape", "", "."} {
+		if err := local.Put(ctx, key, []byte("data")); err == nil {
+			t.Errorf("%q: expected Put to reject the key", key)
+		}
+		if _, err := local.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("%q: expected Get to reject the key, got '%v'", key, err)
+		}
+		if err := local.Delete(ctx, key); err == nil {
+			t.Errorf("%q: expected Delete to reject the key", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Error("a blob was written outside the store")
+	}
+}
+
+// Replaces the blob records in Postgres for the test
+func fakeRecords(t *testing.T, unreferenced []string) (recorded map[string]int, deleted *[]string) {
+	recorded = map[string]int{}
+	deleted = &[]string{}
+	oldRecord, oldExpire, oldUnreferenced, oldDelete := recordBlob, expireOriginals, getUnreferencedBlobs, deleteBlobRecord
+	t.Cleanup(func() {
+		recordBlob, expireOriginals, getUnreferencedBlobs, deleteBlobRecord = oldRecord, oldExpire, oldUnreferenced, oldDelete
+	})
+	recordBlob = func(ctx context.Context, key string, size int) error {
+		recorded[key] = size
+		return nil
+	}
+	expireOriginals = func(ctx context.Context) (int64, error) {
+		return 0, nil
+	}
+	getUnreferencedBlobs = func(ctx context.Context, storedBefore time.Time) ([]string, error) {
+		if time.Since(storedBefore) < SWEEP_GRACE {
+			t.Errorf("blobs stored %v ago are swept", time.Since(storedBefore))
+		}
+		return unreferenced, nil
+	}
+	deleteBlobRecord = func(ctx context.Context, key string) error {
+		*deleted = append(*deleted, key)
+		return nil
+	}
+	return recorded, deleted
+}
+
+func useStore(t *testing.T, s Store) {
+	old := store
+	t.Cleanup(func() { store = old })
+	SetStore(s)
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	recorded, _ := fakeRecords(t, nil)
+	useStore(t, LocalStore{Dir: t.TempDir()})
+
+	result := extract.Result{
+		Text:      "page one\fpage two",
+		Size:      4,
+		MimeType:  "application/pdf",
+		PageCount: 2,
+		Segments:  []extract.Segment{{Page: 1, HeadingPath: []string{"Intro"}, Text: "page one"}},
+		Data:      []byte("%PDF"),
+	}
+	originalKey, textKey := Archive(ctx, result)
+	if !strings.HasPrefix(originalKey, ORIGINALS_PREFIX) || !strings.HasPrefix(textKey, TEXT_PREFIX) {
+		t.Fatalf("unexpected keys %q and %q", originalKey, textKey)
+	}
+	if recorded[originalKey] != len(result.Data) || recorded[textKey] == 0 {
+		t.Errorf("blobs weren't recorded: %v", recorded)
+	}
+
+	text, err := LoadText(ctx, textKey)
+	if err != nil {
+		t.Fatalf("LoadText failed: %v", err)
+	}
+	if text.Text != result.Text || text.PageCount != 2 || !reflect.DeepEqual(text.Segments, result.Segments) {
+		t.Errorf("LoadText returned %+v", text)
+	}
+	if text.Data != nil {
+		t.Error("the original file was stored with the text")
+	}
+	original, err := LoadOriginal(ctx, originalKey)
+	if err != nil || !bytes.Equal(original, result.Data) {
+		t.Errorf("LoadOriginal returned %q, %v", original, err)
+	}
+
+	if _, err := LoadText(ctx, TEXT_PREFIX+"missing.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected error '%v', got '%v'", ErrNotFound, err)
+	}
+
+	if originalKey, textKey := Archive(ctx, extract.Result{Text: "link text"}); originalKey != "" || textKey == "" {
+		t.Errorf("expected only a text key without data, got %q and %q", originalKey, textKey)
+	}
+
+	store = nil
+	if originalKey, textKey := Archive(ctx, result); originalKey != "" || textKey != "" {
+		t.Errorf("expected no keys with storage off, got %q and %q", originalKey, textKey)
+	}
+}
+
+// A store whose deletes fail for one key
+type failingStore struct {
+	Store
+	failKey string
+}
+
+func (f failingStore) Delete(ctx context.Context, key string) error {
+	if key == f.failKey {
+		return fmt.Errorf("delete failed")
+	}
+	return f.Store.Delete(ctx, key)
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	_, deleted := fakeRecords(t, []string{"originals/old", "text/old.json", "originals/stuck"})
+	local := LocalStore{Dir: t.TempDir()}
+	useStore(t, failingStore{Store: local, failKey: "originals/stuck"})
+
+	for _, key := range []string{"originals/old", "text/old.json", "originals/stuck", "originals/kept"} {
+		if err := local.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	sweep(ctx)
+
+	if want := []string{"originals/old", "text/old.json"}; !slices.Equal(*deleted, want) {
+		t.Errorf("deleted records %v, expected %v", *deleted, want)
+	}
+	for key, kept := range map[string]bool{"originals/old": false, "text/old.json": false, "originals/stuck": true, "originals/kept": true} {
+		_, err := local.Get(ctx, key)
+		if kept && err != nil {
+			t.Errorf("%s was deleted: %v", key, err)
+		}
+		if !kept && !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s wasn't deleted: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Where original files and extracted text are kept, keyed by content hash
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error) // ErrNotFound if missing
+	Delete(ctx context.Context, key string) error        // no error if missing
+}
+
+var store Store // nil = blob storage is off, only URLs are kept
+
+// Picks the store from BLOB_STORE: "local" (BLOB_DIR), "s3" (S3_* variables) or empty for none
+func Init() error {
+	switch os.Getenv("BLOB_STORE") {
+	case "":
+		log.Println("BLOB_STORE not set, uploaded files won't be kept")
+		return nil
+	case "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "blobs"
+		}
+		store = LocalStore{Dir: dir}
+	case "s3":
+		s3, err := NewS3Store(
+			os.Getenv("S3_ENDPOINT"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_REGION"),
+			os.Getenv("S3_ACCESS_KEY_ID"),
+			os.Getenv("S3_SECRET_ACCESS_KEY"),
+		)
+		if err != nil {
+			return err
+		}
+		store = s3
+	default:
+		return fmt.Errorf("unknown BLOB_STORE '%s'", os.Getenv("BLOB_STORE"))
+	}
+	return nil
+}
+
+// Swaps the store, nil turns blob storage off
+func SetStore(s Store) {
+	store = s
+}
+
+func Enabled() bool {
+	return store != nil
+}
+
+// Keeps blobs as files under Dir, keys are relative paths
+type LocalStore struct {
+	Dir string
+}
+
+func (l LocalStore) path(key string) (string, error) {
+	path := filepath.Join(l.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(l.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key '%s'", key)
+	}
+	return path, nil
+}
+
+// Writes to a temporary file first so a crash never leaves half a blob
+func (l LocalStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l LocalStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (l LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
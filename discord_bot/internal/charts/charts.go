@@ -0,0 +1,388 @@
+package charts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	Width     = 800
+	Height    = 500
+	MaxPoints = 50
+)
+
+const (
+	TypeBar  = "bar"
+	TypeLine = "line"
+	TypePie  = "pie"
+)
+
+// Chart spec the LLM writes inside a ```chart block.
+// Either Labels/Values are given directly, or LabelColumn/ValueColumn
+// point at columns of the uploaded spreadsheet.
+type ChartSpec struct {
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	Labels      []string  `json:"labels,omitempty"`
+	Values      []float64 `json:"values,omitempty"`
+	LabelColumn string    `json:"label_column,omitempty"`
+	ValueColumn string    `json:"value_column,omitempty"`
+}
+
+var (
+	chartBlockRegex = regexp.MustCompile("(?s)```chart\\s*\\n(.*?)```")
+
+	background = color.RGBA{255, 255, 255, 255}
+	foreground = color.RGBA{40, 40, 40, 255}
+	gridColor  = color.RGBA{220, 220, 220, 255}
+	palette    = []color.RGBA{
+		{88, 101, 242, 255},
+		{87, 242, 135, 255},
+		{254, 231, 92, 255},
+		{235, 69, 158, 255},
+		{237, 66, 69, 255},
+		{52, 152, 219, 255},
+		{155, 89, 182, 255},
+		{230, 126, 34, 255},
+	}
+)
+
+// Pulls the first ```chart block out of an LLM response.
+// Returns the remaining text and nil if there is no valid block.
+func ExtractSpec(response string) (*ChartSpec, string) {
+	match := chartBlockRegex.FindStringSubmatchIndex(response)
+	if match == nil {
+		return nil, response
+	}
+	rest := strings.TrimSpace(response[:match[0]] + response[match[1]:])
+
+	var spec ChartSpec
+	if err := json.Unmarshal([]byte(response[match[2]:match[3]]), &spec); err != nil {
+		return nil, rest
+	}
+	spec.Type = strings.ToLower(strings.TrimSpace(spec.Type))
+	return &spec, rest
+}
+
+// Fills Labels/Values from spreadsheet rows when the spec references columns.
+func (spec *ChartSpec) ResolveColumns(rows []map[string]string) error {
+	if spec.LabelColumn == "" || spec.ValueColumn == "" {
+		return nil
+	}
+	spec.Labels = nil
+	spec.Values = nil
+	for _, row := range rows {
+		label, ok := row[spec.LabelColumn]
+		if !ok {
+			continue
+		}
+		value, err := parseNumber(row[spec.ValueColumn])
+		if err != nil {
+			continue
+		}
+		spec.Labels = append(spec.Labels, label)
+		spec.Values = append(spec.Values, value)
+	}
+	if len(spec.Values) == 0 {
+		return fmt.Errorf("no numeric values found in column '%s'", spec.ValueColumn)
+	}
+	return nil
+}
+
+func (spec *ChartSpec) Validate() error {
+	if spec.Type != TypeBar && spec.Type != TypeLine && spec.Type != TypePie {
+		return fmt.Errorf("unsupported chart type '%s'", spec.Type)
+	}
+	if len(spec.Values) == 0 {
+		return fmt.Errorf("chart has no values")
+	}
+	if len(spec.Labels) != len(spec.Values) {
+		return fmt.Errorf("chart has %d labels but %d values", len(spec.Labels), len(spec.Values))
+	}
+	if len(spec.Values) > MaxPoints {
+		spec.Labels = spec.Labels[:MaxPoints]
+		spec.Values = spec.Values[:MaxPoints]
+	}
+	total := 0.0
+	for _, v := range spec.Values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("chart values must be finite numbers")
+		}
+		if spec.Type == TypePie && v < 0 {
+			return fmt.Errorf("pie charts can't have negative values")
+		}
+		total += v
+	}
+	// Values near the float limits overflow the axis range or the pie's total
+	if minV, maxV := valueRange(spec.Values); math.IsInf(maxV-minV, 0) || math.IsInf(total, 0) {
+		return fmt.Errorf("chart values are too large")
+	}
+	return nil
+}
+
+// Renders the chart as a PNG
+func Render(spec ChartSpec) ([]byte, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{background}, image.Point{}, draw.Src)
+	drawText(img, spec.Title, (Width-textWidth(spec.Title))/2, 30, foreground)
+
+	switch spec.Type {
+	case TypeBar:
+		drawBarChart(img, spec)
+	case TypeLine:
+		drawLineChart(img, spec)
+	case TypePie:
+		drawPieChart(img, spec)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode chart: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Plot area shared by bar and line charts
+var plot = image.Rect(80, 60, Width-40, Height-80)
+
+func drawAxes(img *image.RGBA, minV float64, maxV float64) {
+	for i := 0; i <= 4; i++ {
+		y := plot.Max.Y - i*plot.Dy()/4
+		drawLine(img, plot.Min.X, y, plot.Max.X, y, gridColor)
+		label := formatNumber(minV + (maxV-minV)*float64(i)/4)
+		drawText(img, label, plot.Min.X-textWidth(label)-6, y+4, foreground)
+	}
+	drawLine(img, plot.Min.X, plot.Min.Y, plot.Min.X, plot.Max.Y, foreground)
+	drawLine(img, plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y, foreground)
+}
+
+func drawXLabels(img *image.RGBA, labels []string, centers []int) {
+	slot := plot.Dx() / len(labels)
+	maxChars := max(slot/7, 3)
+	// Skip labels when they'd overlap each other
+	step := 1
+	if slot < 20 {
+		step = (20 + slot - 1) / slot
+	}
+	for i := 0; i < len(labels); i += step {
+		label := truncate(labels[i], maxChars*step)
+		drawText(img, label, centers[i]-textWidth(label)/2, plot.Max.Y+18, foreground)
+	}
+}
+
+func valueRange(values []float64) (float64, float64) {
+	minV, maxV := 0.0, 0.0
+	for _, v := range values {
+		minV = math.Min(minV, v)
+		maxV = math.Max(maxV, v)
+	}
+	if minV == maxV {
+		maxV = minV + 1
+	}
+	return minV, maxV
+}
+
+func yFor(v float64, minV float64, maxV float64) int {
+	y := plot.Max.Y - int((v-minV)/(maxV-minV)*float64(plot.Dy()))
+	return min(max(y, plot.Min.Y), plot.Max.Y)
+}
+
+func drawBarChart(img *image.RGBA, spec ChartSpec) {
+	minV, maxV := valueRange(spec.Values)
+	drawAxes(img, minV, maxV)
+
+	slot := plot.Dx() / len(spec.Values)
+	barWidth := max(slot*7/10, 1)
+	zeroY := yFor(0, minV, maxV)
+	var centers []int
+	for i, v := range spec.Values {
+		x0 := plot.Min.X + i*slot + (slot-barWidth)/2
+		y := yFor(v, minV, maxV)
+		bar := image.Rect(x0, min(y, zeroY), x0+barWidth, max(y, zeroY))
+		draw.Draw(img, bar, &image.Uniform{palette[0]}, image.Point{}, draw.Src)
+		centers = append(centers, x0+barWidth/2)
+	}
+	drawXLabels(img, spec.Labels, centers)
+}
+
+func drawLineChart(img *image.RGBA, spec ChartSpec) {
+	minV, maxV := valueRange(spec.Values)
+	drawAxes(img, minV, maxV)
+
+	slot := plot.Dx() / len(spec.Values)
+	var centers []int
+	prevX, prevY := 0, 0
+	for i, v := range spec.Values {
+		x := plot.Min.X + i*slot + slot/2
+		y := yFor(v, minV, maxV)
+		if i > 0 {
+			drawThickLine(img, prevX, prevY, x, y, palette[0])
+		}
+		fillCircle(img, x, y, 4, palette[0])
+		centers = append(centers, x)
+		prevX, prevY = x, y
+	}
+	drawXLabels(img, spec.Labels, centers)
+}
+
+func drawPieChart(img *image.RGBA, spec ChartSpec) {
+	total := 0.0
+	for _, v := range spec.Values {
+		total += v
+	}
+	if total == 0 {
+		drawText(img, "All values are zero", Width/2-60, Height/2, foreground)
+		return
+	}
+
+	cx, cy, r := 260, Height/2+20, 180
+	// Cumulative angle where each slice ends
+	var ends []float64
+	acc := 0.0
+	for _, v := range spec.Values {
+		acc += v / total * 2 * math.Pi
+		ends = append(ends, acc)
+	}
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			dx, dy := float64(x-cx), float64(y-cy)
+			if dx*dx+dy*dy > float64(r*r) {
+				continue
+			}
+			// Start at 12 o'clock and go clockwise
+			angle := math.Atan2(dx, -dy)
+			if angle < 0 {
+				angle += 2 * math.Pi
+			}
+			slice := 0
+			for slice < len(ends)-1 && angle > ends[slice] {
+				slice++
+			}
+			img.Set(x, y, palette[slice%len(palette)])
+		}
+	}
+
+	// Legend
+	legendX, legendY := cx+r+40, cy-r
+	for i, label := range spec.Labels {
+		y := legendY + i*20
+		if y > Height-20 {
+			drawText(img, fmt.Sprintf("+%d more", len(spec.Labels)-i), legendX, y+10, foreground)
+			break
+		}
+		swatch := image.Rect(legendX, y, legendX+12, y+12)
+		draw.Draw(img, swatch, &image.Uniform{palette[i%len(palette)]}, image.Point{}, draw.Src)
+		text := fmt.Sprintf("%s (%.1f%%)", truncate(label, 30), spec.Values[i]/total*100)
+		drawText(img, text, legendX+18, y+11, foreground)
+	}
+}
+
+func drawText(img *image.RGBA, text string, x int, y int, c color.Color) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+func textWidth(text string) int {
+	return font.MeasureString(basicfont.Face7x13, text).Round()
+}
+
+func drawLine(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func drawThickLine(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.Color) {
+	for off := -1; off <= 1; off++ {
+		drawLine(img, x0, y0+off, x1, y1+off, c)
+	}
+}
+
+func fillCircle(img *image.RGBA, cx int, cy int, r int, c color.Color) {
+	for y := -r; y <= r; y++ {
+		for x := -r; x <= r; x++ {
+			if x*x+y*y <= r*r {
+				img.Set(cx+x, cy+y, c)
+			}
+		}
+	}
+}
+
+func parseNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.NewReplacer(",", "", "$", "", "%", "").Replace(s)
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return 0, fmt.Errorf("'%s' isn't a finite number", s)
+	}
+	return v, err
+}
+
+func formatNumber(v float64) string {
+	if math.Abs(v) >= 1000 || v == math.Trunc(v) {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	// basicfont only has ASCII glyphs
+	if n <= 3 {
+		return string(r[:n])
+	}
+	return string(r[:n-3]) + "..."
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
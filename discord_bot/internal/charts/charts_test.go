@@ -0,0 +1,179 @@
+package charts
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantType string // empty = no spec
+		wantRest string
+	}{
+		{
+			name:     "block",
+			response: "Sales by month:\n```chart\n{\"type\": \" Bar \", \"title\": \"Sales\", \"labels\": [\"a\"], \"values\": [1]}\n```\nDone.",
+			wantType: TypeBar,
+			wantRest: "Sales by month:\n\nDone.",
+		},
+		{
+			name:     "no block",
+			response: "Just text",
+			wantRest: "Just text",
+		},
+		{
+			name:     "invalid json",
+			response: "Before\n```chart\n{not json}\n```",
+			wantRest: "Before",
+		},
+		{
+			name:     "only the first block",
+			response: "```chart\n{\"type\": \"pie\"}\n```\n```chart\n{\"type\": \"line\"}\n```",
+			wantType: TypePie,
+			wantRest: "```chart\n{\"type\": \"line\"}\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, rest := ExtractSpec(tt.response)
+			if rest != tt.wantRest {
+				t.Errorf("rest is %q, expected %q", rest, tt.wantRest)
+			}
+			if tt.wantType == "" {
+				if spec != nil {
+					t.Errorf("expected no spec, got %+v", spec)
+				}
+				return
+			}
+			if spec == nil || spec.Type != tt.wantType {
+				t.Fatalf("expected a %s spec, got %+v", tt.wantType, spec)
+			}
+		})
+	}
+}
+
+func TestResolveColumns(t *testing.T) {
+	rows := ParseRows("(Month: Jan,\tSales: $1,200)\n(Month: Feb,\tSales: 15%)\n(Month: Mar,\tSales: n/a)\n(Month: Apr,\tSales: NaN)\n(Month: May,\tSales: Inf)\n(Month: Jun,\tSales: -3.5)\nnot a row")
+
+	spec := ChartSpec{Type: TypeBar, LabelColumn: "Month", ValueColumn: "Sales", Labels: []string{"stale"}}
+	if err := spec.ResolveColumns(rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"Jan", "Feb", "Jun"}; !slices.Equal(spec.Labels, want) {
+		t.Errorf("labels are %v, expected %v", spec.Labels, want)
+	}
+	if want := []float64{1200, 15, -3.5}; !slices.Equal(spec.Values, want) {
+		t.Errorf("values are %v, expected %v", spec.Values, want)
+	}
+
+	missing := ChartSpec{Type: TypeBar, LabelColumn: "Month", ValueColumn: "Profit"}
+	if err := missing.ResolveColumns(rows); err == nil {
+		t.Error("expected an error for a column with no numbers")
+	}
+
+	direct := ChartSpec{Type: TypeBar, Labels: []string{"a"}, Values: []float64{1}}
+	if err := direct.ResolveColumns(rows); err != nil || !slices.Equal(direct.Values, []float64{1}) {
+		t.Errorf("spec without columns changed: %v, %v", direct.Values, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    ChartSpec
+		wantErr bool
+	}{
+		{name: "bar", spec: ChartSpec{Type: TypeBar, Labels: []string{"a", "b"}, Values: []float64{1, -2}}},
+		{name: "unknown type", spec: ChartSpec{Type: "scatter", Labels: []string{"a"}, Values: []float64{1}}, wantErr: true},
+		{name: "no values", spec: ChartSpec{Type: TypeBar}, wantErr: true},
+		{name: "label count", spec: ChartSpec{Type: TypeLine, Labels: []string{"a"}, Values: []float64{1, 2}}, wantErr: true},
+		{name: "negative pie", spec: ChartSpec{Type: TypePie, Labels: []string{"a", "b"}, Values: []float64{1, -1}}, wantErr: true},
+		{name: "nan", spec: ChartSpec{Type: TypeBar, Labels: []string{"a"}, Values: []float64{math.NaN()}}, wantErr: true},
+		{name: "inf", spec: ChartSpec{Type: TypeLine, Labels: []string{"a"}, Values: []float64{math.Inf(-1)}}, wantErr: true},
+		{name: "range overflow", spec: ChartSpec{Type: TypeLine, Labels: []string{"a", "b", "c"}, Values: []float64{1, math.MaxFloat64, -math.MaxFloat64}}, wantErr: true},
+		{name: "pie total overflow", spec: ChartSpec{Type: TypePie, Labels: []string{"a", "b"}, Values: []float64{math.MaxFloat64, math.MaxFloat64}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, expected error: %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	long := ChartSpec{Type: TypeBar}
+	for i := 0; i < MaxPoints+10; i++ {
+		long.Labels = append(long.Labels, strings.Repeat("x", i))
+		long.Values = append(long.Values, float64(i))
+	}
+	if err := long.Validate(); err != nil || len(long.Values) != MaxPoints || len(long.Labels) != MaxPoints {
+		t.Errorf("expected %d points, got %d values and %d labels (%v)", MaxPoints, len(long.Values), len(long.Labels), err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	labels := func(n int) []string {
+		var l []string
+		for i := 0; i < n; i++ {
+			l = append(l, strings.Repeat("label", 5))
+		}
+		return l
+	}
+	tests := []struct {
+		name    string
+		spec    ChartSpec
+		wantErr bool
+	}{
+		{name: "bar", spec: ChartSpec{Type: TypeBar, Title: "Sales", Labels: []string{"Jan", "Feb", "Mar"}, Values: []float64{10, 25.5, -4}}},
+		{name: "line", spec: ChartSpec{Type: TypeLine, Title: "Trend", Labels: []string{"Q1", "Q2", "Q3", "Q4"}, Values: []float64{1, 3, 2, 5}}},
+		{name: "pie", spec: ChartSpec{Type: TypePie, Title: "Share", Labels: []string{"a", "b", "c"}, Values: []float64{50, 30, 20}}},
+		{name: "single point", spec: ChartSpec{Type: TypeLine, Labels: []string{"only"}, Values: []float64{7}}},
+		{name: "all equal", spec: ChartSpec{Type: TypeBar, Labels: []string{"a", "b"}, Values: []float64{0, 0}}},
+		{name: "zero pie", spec: ChartSpec{Type: TypePie, Labels: []string{"a", "b"}, Values: []float64{0, 0}}},
+		{name: "many long labels", spec: ChartSpec{Type: TypeBar, Labels: labels(MaxPoints), Values: make([]float64, MaxPoints)}},
+		{name: "huge values", spec: ChartSpec{Type: TypeLine, Labels: []string{"a", "b"}, Values: []float64{math.MaxFloat64 / 2, -math.MaxFloat64 / 2}}},
+		{name: "overflowing range", spec: ChartSpec{Type: TypeLine, Labels: []string{"a", "b", "c"}, Values: []float64{1, math.MaxFloat64, -math.MaxFloat64}}, wantErr: true},
+		{name: "invalid", spec: ChartSpec{Type: TypePie}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			var data []byte
+			var err error
+			go func() {
+				data, err = Render(tt.spec)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Render didn't return")
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("not a PNG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+				t.Errorf("image is %dx%d, expected %dx%d", b.Dx(), b.Dy(), Width, Height)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package charts
+
+import (
+	"strings"
+)
+
+// Parses spreadsheet rows in the format parser_api produces for csv/xlsx:
+//
+//	(header1: value1,	header2: value2)
+func ParseRows(text string) []map[string]string {
+	var rows []map[string]string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "(") || !strings.HasSuffix(line, ")") {
+			continue
+		}
+		line = line[1 : len(line)-1]
+
+		row := make(map[string]string)
+		for _, cell := range strings.Split(line, ",\t") {
+			header, value, found := strings.Cut(cell, ": ")
+			if !found {
+				header, value, _ = strings.Cut(cell, ":")
+			}
+			row[strings.TrimSpace(header)] = strings.TrimSpace(value)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
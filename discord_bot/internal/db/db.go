@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
@@ -32,6 +34,9 @@ func InitDB() {
 	if err != nil {
 		log.Fatal("Unable to connect to database:", err)
 	}
+	if err = RunMigrations(context.Background()); err != nil {
+		log.Fatal("Unable to migrate database:", err)
+	}
 
 	opts, err := redis.ParseURL(REDIS_URL)
 	if err != nil {
@@ -256,6 +261,89 @@ func AddMessageLog(message_id string, discord_server_id string, channel_id strin
 	}
 }
 
+func AddAnswer(ctx context.Context, answer Answer) (int, error) {
+	sources, err := json.Marshal(answer.Sources)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = DbPool.QueryRow(ctx, `
+		INSERT INTO answers (
+			answer_message_id, message_ids, question_message_id, question_channel_id, log_message_id, asker_id,
+			discord_server_id, channel_id, llm_company, llm_model, retrieval_limit, sources
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`, answer.AnswerMessageID, answer.MessageIDs, answer.QuestionMessageID, answer.QuestionChannelID, answer.LogMessageID, answer.AskerID,
+		answer.ServerID, answer.ChannelID, answer.Company, answer.Model, answer.RetrievalLimit, sources).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error saving answer: %v", err)
+	}
+	return id, nil
+}
+
+// The latest answer shown in the message, a regenerated answer reuses its messages. nil if there's none.
+func GetAnswer(ctx context.Context, answerMessageID string) (*Answer, error) {
+	var answer Answer
+	var sources []byte
+	err := DbPool.QueryRow(ctx, `
+		SELECT id, answer_message_id, message_ids, question_message_id, question_channel_id, COALESCE(log_message_id, ''), asker_id,
+			discord_server_id, channel_id, llm_company, llm_model, retrieval_limit, sources
+		FROM answers WHERE answer_message_id = $1
+		ORDER BY id DESC LIMIT 1
+	`, answerMessageID).Scan(&answer.ID, &answer.AnswerMessageID, &answer.MessageIDs, &answer.QuestionMessageID, &answer.QuestionChannelID, &answer.LogMessageID, &answer.AskerID,
+		&answer.ServerID, &answer.ChannelID, &answer.Company, &answer.Model, &answer.RetrievalLimit, &sources)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(sources, &answer.Sources); err != nil {
+		return nil, fmt.Errorf("error decoding answer sources: %v", err)
+	}
+	return &answer, nil
+}
+
+// Records the user's rating of the answer, replacing an earlier one. A changed rating drops the old reason.
+func SetAnswerFeedback(ctx context.Context, answerID int, userID string, rating int) error {
+	_, err := DbPool.Exec(ctx, `
+		INSERT INTO answer_feedback (answer_id, user_id, rating) VALUES ($1, $2, $3)
+		ON CONFLICT (answer_id, user_id) DO UPDATE
+		SET rating = EXCLUDED.rating,
+			reason = CASE WHEN answer_feedback.rating = EXCLUDED.rating THEN answer_feedback.reason END,
+			created_at = CURRENT_TIMESTAMP
+	`, answerID, userID, rating)
+	return err
+}
+
+func SetAnswerFeedbackReason(ctx context.Context, answerID int, userID string, reason string) error {
+	_, err := DbPool.Exec(ctx, `
+		UPDATE answer_feedback SET reason = NULLIF($3, '') WHERE answer_id = $1 AND user_id = $2
+	`, answerID, userID, reason)
+	return err
+}
+
+// Feedback on the server's answers per model, most rated first
+func GetAnswerFeedbackStats(ctx context.Context, serverID string) ([]FeedbackStats, error) {
+	rows, err := DbPool.Query(ctx, `
+		SELECT a.llm_company, a.llm_model,
+			COUNT(*) FILTER (WHERE f.rating > 0), COUNT(*) FILTER (WHERE f.rating < 0)
+		FROM answer_feedback f
+		JOIN answers a ON a.id = f.answer_id
+		WHERE a.discord_server_id = $1
+		GROUP BY a.llm_company, a.llm_model
+		ORDER BY COUNT(*) DESC
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (FeedbackStats, error) {
+		var stats FeedbackStats
+		err := row.Scan(&stats.Company, &stats.Model, &stats.Positive, &stats.Negative)
+		return stats, err
+	})
+}
+
 func UpdateAllowedChannels(allowedChannels []string, serverID string) error {
 	query := `
 		UPDATE joined_servers
@@ -297,7 +385,7 @@ func GetAllowedChannels(serverID string) ([]string, error) {
 	return allowedChannels, nil
 }
 
-func GetServersLLMConfig(serverID string) (company string, model string, err error) {
+func getServersLLMConfig(serverID string) (company string, model string, err error) {
 	ctx := context.Background()
 	companyKey := fmt.Sprintf(`server_%s_llm_company`, serverID)
 	modelKey := fmt.Sprintf(`server_%s_llm_model`, serverID)
@@ -326,6 +414,697 @@ func GetServersLLMConfig(serverID string) (company string, model string, err err
 	return dbCompany, dbModel, nil
 }
 
+// Effective LLM config for a channel: the server's settings with the channel's overrides on top
+func ResolveLLMConfig(serverID string, channelID string) (LLMConfig, error) {
+	company, model, err := getServersLLMConfig(serverID)
+	if err != nil {
+		return LLMConfig{}, err
+	}
+	params, err := GetServerGenerationParams(serverID)
+	if err != nil {
+		log.Printf("Error getting generation params for %s: %v", serverID, err)
+	}
+	config := LLMConfig{
+		Company:    company,
+		Model:      model,
+		AutoThread: true,
+		Params:     params,
+	}
+
+	settings, err := GetChannelSettings(channelID)
+	if err != nil {
+		log.Printf("Error getting channel settings for %s: %v", channelID, err)
+		return config, nil
+	}
+	if settings.LLMCompany != nil && settings.LLMModel != nil {
+		config.Company = *settings.LLMCompany
+		config.Model = *settings.LLMModel
+	}
+	if settings.Persona != nil {
+		config.Persona = *settings.Persona
+	}
+	if settings.RetrievalDepth != nil {
+		config.RetrievalDepth = *settings.RetrievalDepth
+	}
+	if settings.AutoThread != nil {
+		config.AutoThread = *settings.AutoThread
+	}
+	return config, nil
+}
+
+func GetChannelSettings(channelID string) (ChannelSettings, error) {
+	redis_key := fmt.Sprintf(`channel_%s_settings`, channelID)
+	cached, redis_err := RedisClient.Get(context.Background(), redis_key).Result()
+	var settings ChannelSettings
+
+	err := json.Unmarshal([]byte(cached), &settings)
+	if redis_err == nil && err == nil {
+		log.Println("Channel settings cache hit")
+		return settings, nil
+	}
+
+	log.Printf("Not found in cache: %s", redis_key)
+	err = DbPool.QueryRow(context.Background(), `
+		SELECT llm_company, llm_model, persona, retrieval_depth, auto_thread
+		FROM channel_settings
+		WHERE channel_id = $1
+	`, channelID).Scan(&settings.LLMCompany, &settings.LLMModel, &settings.Persona, &settings.RetrievalDepth, &settings.AutoThread)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return ChannelSettings{}, err
+	}
+	// channels without overrides are cached too, most channels never get any
+	UpdateJSONToRedis(redis_key, settings)
+	return settings, nil
+}
+
+func UpdateChannelSettings(channelID string, serverID string, settings ChannelSettings) error {
+	_, err := DbPool.Exec(context.Background(), `
+		INSERT INTO channel_settings
+			(channel_id, discord_server_id, llm_company, llm_model, persona, retrieval_depth, auto_thread)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (channel_id) DO UPDATE SET
+			llm_company = EXCLUDED.llm_company,
+			llm_model = EXCLUDED.llm_model,
+			persona = EXCLUDED.persona,
+			retrieval_depth = EXCLUDED.retrieval_depth,
+			auto_thread = EXCLUDED.auto_thread
+	`, channelID, serverID, settings.LLMCompany, settings.LLMModel, settings.Persona, settings.RetrievalDepth, settings.AutoThread)
+	if err != nil {
+		return err
+	}
+
+	redis_key := fmt.Sprintf(`channel_%s_settings`, channelID)
+	if err = UpdateJSONToRedis(redis_key, settings); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+func ResetChannelSettings(channelID string) error {
+	_, err := DbPool.Exec(context.Background(), `
+		DELETE FROM channel_settings WHERE channel_id = $1`, channelID)
+	if err != nil {
+		return err
+	}
+
+	redis_key := fmt.Sprintf(`channel_%s_settings`, channelID)
+	if err = UpdateJSONToRedis(redis_key, ChannelSettings{}); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// Message IDs whose documents are part of a thread, besides the thread's root message
+func GetThreadDocuments(threadID string) ([]string, error) {
+	redis_key := fmt.Sprintf(`thread_%s_documents`, threadID)
+	cached, redis_err := RedisClient.Get(context.Background(), redis_key).Result()
+	var messageIDs []string
+
+	err := json.Unmarshal([]byte(cached), &messageIDs)
+	if redis_err == nil && err == nil {
+		log.Println("Thread documents cache hit")
+		return messageIDs, nil
+	}
+
+	log.Printf("Not found in cache: %s", redis_key)
+	rows, err := DbPool.Query(context.Background(), `
+		SELECT message_id FROM thread_documents
+		WHERE thread_id = $1
+		ORDER BY added_at
+	`, threadID)
+	if err != nil {
+		return nil, err
+	}
+	messageIDs, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	if messageIDs == nil {
+		messageIDs = []string{}
+	}
+	UpdateJSONToRedis(redis_key, messageIDs)
+	return messageIDs, nil
+}
+
+func AddThreadDocument(threadID string, messageID string, serverID string) error {
+	_, err := DbPool.Exec(context.Background(), `
+		INSERT INTO thread_documents (thread_id, message_id, discord_server_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, threadID, messageID, serverID)
+	if err != nil {
+		return err
+	}
+
+	redis_key := fmt.Sprintf(`thread_%s_documents`, threadID)
+	if err = RedisClient.Del(context.Background(), redis_key).Err(); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// Creates the document with its uploaded_files row, which counts towards the
+// owner's storage, in one transaction
+func CreateDocument(ctx context.Context, doc Document) (int, error) {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO uploaded_files (discord_server_id, channel_id, uploader_id, title, file_url, file_size, message_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, doc.ServerID, doc.ChannelID, doc.UploaderID, doc.Title, doc.SourceURL, doc.FileSize, doc.MessageID)
+	if err != nil {
+		return 0, fmt.Errorf("error uploading to uploaded_files: %v", err)
+	}
+
+	var id int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO documents (
+			discord_server_id, channel_id, thread_id, message_id, uploader_id, title, source_url,
+			content_hash, mime_type, file_size, page_count, status, embedding_model, tags, original_key, text_key,
+			chunker_version, job_id
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, NULLIF($11, 0), $12, NULLIF($13, ''), COALESCE($14, '{}'::TEXT[]), NULLIF($15, ''), NULLIF($16, ''),
+			NULLIF($17, 0), NULLIF($18, ''))
+		RETURNING id
+	`, doc.ServerID, doc.ChannelID, doc.ThreadID, doc.MessageID, doc.UploaderID, doc.Title, doc.SourceURL,
+		doc.ContentHash, doc.MimeType, doc.FileSize, doc.PageCount, doc.Status, doc.EmbeddingModel, doc.Tags,
+		doc.OriginalKey, doc.TextKey, doc.ChunkerVersion, doc.JobID).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating document: %v", err)
+	}
+	return id, tx.Commit(ctx)
+}
+
+// The document an earlier attempt of the ingestion job created, 0 if there's none
+func GetJobDocument(ctx context.Context, jobID string) (int, error) {
+	var id int
+	err := DbPool.QueryRow(ctx, `SELECT id FROM documents WHERE job_id = $1`, jobID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// Deletes the document's chunks and marks it processing, so it can be chunked again
+func ResetDocument(ctx context.Context, documentID int) error {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM chunks WHERE document_id = $1 AND reindex_run_id IS NULL`, documentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE documents SET status = $2 WHERE id = $1`, documentID, DocumentProcessing); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func SetDocumentStatus(ctx context.Context, documentID int, status string) error {
+	_, err := DbPool.Exec(ctx, `UPDATE documents SET status = $2 WHERE id = $1`, documentID, status)
+	return err
+}
+
+// Replaces the tags of the documents posted in a forum post
+func UpdateThreadDocumentTags(ctx context.Context, threadID string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	_, err := DbPool.Exec(ctx, `UPDATE documents SET tags = $2 WHERE thread_id = $1`, threadID, tags)
+	return err
+}
+
+// The messages that have indexed documents, out of messageIDs
+func GetIndexedMessages(ctx context.Context, messageIDs []string) ([]string, error) {
+	rows, err := DbPool.Query(ctx, `
+		SELECT DISTINCT message_id FROM documents
+		WHERE message_id = ANY($1)
+	`, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// Whether the channel or thread has documents, like ones indexed before the
+// channel was removed from the allowed channels
+func ChannelHasDocuments(ctx context.Context, serverID string, channelID string) (bool, error) {
+	var found bool
+	err := DbPool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM documents
+			WHERE discord_server_id = $1 AND (channel_id = $2 OR thread_id = $2)
+		)
+	`, serverID, channelID).Scan(&found)
+	return found, err
+}
+
+// Removes the messages' documents, chunks, upload records and thread links in
+// one transaction, along with the answers that quoted them or were given in
+// their threads. Returns the threads the documents were part of.
+func DeleteMessageDocuments(ctx context.Context, messageIDs []string) ([]string, error) {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT thread_id FROM documents WHERE message_id = ANY($1) AND thread_id IS NOT NULL
+		UNION
+		SELECT thread_id FROM thread_documents WHERE message_id = ANY($1)
+	`, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get threads: %v", err)
+	}
+	threadIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get threads: %v", err)
+	}
+
+	statements := []string{
+		`DELETE FROM chunks WHERE message_id = ANY($1)`,
+		`DELETE FROM documents WHERE message_id = ANY($1)`,
+		`DELETE FROM uploaded_files WHERE message_id = ANY($1)`,
+		// A deleted root message takes the rest of its thread's documents with it
+		`DELETE FROM thread_documents WHERE message_id = ANY($1) OR thread_id = ANY($1)`,
+		`DELETE FROM answers WHERE channel_id = ANY($1) OR EXISTS (
+			SELECT 1 FROM jsonb_array_elements(sources) source WHERE source->>'message_id' = ANY($1)
+		)`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, messageIDs); err != nil {
+			return nil, fmt.Errorf("failed to delete documents: %v", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, threadID := range append(threadIDs, messageIDs...) {
+		redis_key := fmt.Sprintf(`thread_%s_documents`, threadID)
+		if err := RedisClient.Del(ctx, redis_key).Err(); err != nil {
+			log.Println(err)
+		}
+	}
+	log.Printf("Deleted documents of messages: %v", messageIDs)
+	return threadIDs, nil
+}
+
+// Creates the channel's history document, pinned into the knowledge base
+func EnableChannelHistory(ctx context.Context, doc Document) (ChannelHistory, error) {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return ChannelHistory{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	history := ChannelHistory{ChannelID: doc.ChannelID, ServerID: doc.ServerID}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO documents (discord_server_id, channel_id, message_id, uploader_id, title, source_url, mime_type, status, embedding_model, chunker_version)
+		VALUES ($1, $2, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0))
+		RETURNING id
+	`, doc.ServerID, doc.ChannelID, doc.UploaderID, doc.Title, doc.SourceURL, doc.MimeType, DocumentProcessing,
+		doc.EmbeddingModel, doc.ChunkerVersion).Scan(&history.DocumentID)
+	if err != nil {
+		return ChannelHistory{}, fmt.Errorf("error creating history document: %v", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO channel_history (channel_id, discord_server_id, document_id, enabled_by)
+		VALUES ($1, $2, $3, $4)
+	`, doc.ChannelID, doc.ServerID, history.DocumentID, doc.UploaderID)
+	if err != nil {
+		return ChannelHistory{}, fmt.Errorf("error enabling channel history: %v", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO knowledge_base (document_id, discord_server_id, added_by)
+		VALUES ($1, $2, $3)
+	`, history.DocumentID, doc.ServerID, doc.UploaderID)
+	if err != nil {
+		return ChannelHistory{}, fmt.Errorf("error adding history to knowledge base: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ChannelHistory{}, err
+	}
+
+	redis_key := fmt.Sprintf(`channel_%s_history`, doc.ChannelID)
+	if err = UpdateJSONToRedis(redis_key, history); err != nil {
+		log.Println(err)
+	}
+	return history, nil
+}
+
+// nil if the channel's history isn't indexed
+func GetChannelHistory(channelID string) (*ChannelHistory, error) {
+	redis_key := fmt.Sprintf(`channel_%s_history`, channelID)
+	cached, redis_err := RedisClient.Get(context.Background(), redis_key).Result()
+	var history *ChannelHistory
+
+	err := json.Unmarshal([]byte(cached), &history)
+	if redis_err == nil && err == nil {
+		return history, nil
+	}
+
+	log.Printf("Not found in cache: %s", redis_key)
+	var lastMessageID *string
+	history = &ChannelHistory{ChannelID: channelID}
+	err = DbPool.QueryRow(context.Background(), `
+		SELECT discord_server_id, document_id, last_message_id FROM channel_history WHERE channel_id = $1
+	`, channelID).Scan(&history.ServerID, &history.DocumentID, &lastMessageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		history = nil
+	} else if err != nil {
+		return nil, err
+	} else if lastMessageID != nil {
+		history.LastMessageID = *lastMessageID
+	}
+	UpdateJSONToRedis(redis_key, history)
+	return history, nil
+}
+
+// Records the newest message indexed, new messages are indexed from there
+func SetChannelHistoryCursor(ctx context.Context, history ChannelHistory) error {
+	_, err := DbPool.Exec(ctx, `
+		UPDATE channel_history SET last_message_id = $2 WHERE channel_id = $1
+	`, history.ChannelID, history.LastMessageID)
+	if err != nil {
+		return err
+	}
+	redis_key := fmt.Sprintf(`channel_%s_history`, history.ChannelID)
+	if err = UpdateJSONToRedis(redis_key, history); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// Deletes the channel's history document with all of its windows
+func DisableChannelHistory(ctx context.Context, channelID string) error {
+	_, err := DbPool.Exec(ctx, `
+		DELETE FROM documents WHERE id = (SELECT document_id FROM channel_history WHERE channel_id = $1)
+	`, channelID)
+	if err != nil {
+		return err
+	}
+	redis_key := fmt.Sprintf(`channel_%s_history`, channelID)
+	if err = RedisClient.Del(ctx, redis_key).Err(); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// Deletes the history windows that contain the messages, and the answers that
+// quoted them. Windows are stored under their first message, so that's the
+// latest window starting at or before each message. Messages after the cursor
+// aren't indexed yet.
+func DeleteHistoryWindows(ctx context.Context, history ChannelHistory, messageIDs []string) error {
+	if history.LastMessageID == "" {
+		return nil
+	}
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// By message ID, so a copy being built by a re-index run goes too
+	rows, err := tx.Query(ctx, `
+		DELETE FROM chunks WHERE document_id = $1 AND message_id IN (
+			SELECT (
+				SELECT c.message_id FROM chunks c
+				WHERE c.document_id = $1 AND c.message_id::BIGINT <= deleted.id::BIGINT
+				ORDER BY c.message_id::BIGINT DESC
+				LIMIT 1
+			)
+			FROM unnest($2::TEXT[]) AS deleted(id)
+			WHERE deleted.id::BIGINT <= $3::BIGINT
+		)
+		RETURNING message_id
+	`, history.DocumentID, messageIDs, history.LastMessageID)
+	if err != nil {
+		return err
+	}
+	windows, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM answers WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements(sources) source WHERE source->>'message_id' = ANY($1)
+		)
+	`, windows)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Records a stored blob. Storing it again restarts its grace period, so the
+// sweep doesn't delete it before the new document referencing it is created.
+func RecordBlob(ctx context.Context, key string, size int) error {
+	_, err := DbPool.Exec(ctx, `
+		INSERT INTO blobs (key, size) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET created_at = CURRENT_TIMESTAMP
+	`, key, size)
+	return err
+}
+
+// Unlinks original files older than their owner's plan keeps them. Returns
+// how many documents lost their original.
+func ExpireOriginals(ctx context.Context) (int64, error) {
+	var expired int64
+	for plan, limits := range planLimitsMap {
+		if limits.OriginalRetention == 0 {
+			continue
+		}
+		tag, err := DbPool.Exec(ctx, `
+			UPDATE documents d SET original_key = NULL
+			FROM joined_servers js
+			JOIN users u ON u.discord_id = js.owner_id
+			WHERE d.discord_server_id = js.discord_server_id
+				AND d.original_key IS NOT NULL
+				AND u.plan = $1
+				AND d.created_at < $2
+		`, plan, time.Now().Add(-limits.OriginalRetention))
+		if err != nil {
+			return expired, fmt.Errorf("failed to expire originals of plan %s: %v", plan, err)
+		}
+		expired += tag.RowsAffected()
+	}
+	return expired, nil
+}
+
+// Blobs no document references, stored before the cutoff
+func GetUnreferencedBlobs(ctx context.Context, storedBefore time.Time) ([]string, error) {
+	rows, err := DbPool.Query(ctx, `
+		SELECT b.key FROM blobs b
+		WHERE b.created_at < $1
+			AND NOT EXISTS (SELECT 1 FROM documents d WHERE d.original_key = b.key OR d.text_key = b.key)
+	`, storedBefore)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func DeleteBlobRecord(ctx context.Context, key string) error {
+	_, err := DbPool.Exec(ctx, `DELETE FROM blobs WHERE key = $1`, key)
+	return err
+}
+
+var ErrReindexRunning = errors.New("a re-index is already running")
+
+const reindexRunColumns = `id, COALESCE(discord_server_id, ''), embedding_model, chunker_version, status, total, done,
+	skipped, last_document_id, started_by, COALESCE(error, ''), started_at`
+
+func scanReindexRun(row pgx.Row) (ReindexRun, error) {
+	var run ReindexRun
+	err := row.Scan(&run.ID, &run.ServerID, &run.EmbeddingModel, &run.ChunkerVersion, &run.Status, &run.Total, &run.Done,
+		&run.Skipped, &run.LastDocumentID, &run.StartedBy, &run.Error, &run.StartedAt)
+	return run, err
+}
+
+// Documents of the server (every server if empty) not in the embedding model and chunker yet
+const reindexDocumentsFilter = `
+	status = 'ready'
+	AND ($1 = '' OR discord_server_id = $1)
+	AND (embedding_model IS DISTINCT FROM $2 OR chunker_version IS DISTINCT FROM $3)`
+
+// Starts a run claimed by worker. Fails with ErrReindexRunning while another
+// run covers the same server.
+func CreateReindexRun(ctx context.Context, run ReindexRun, worker string) (ReindexRun, error) {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return ReindexRun{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serializes run creation, so two can't both see no running run
+	if _, err := tx.Exec(ctx, `LOCK TABLE reindex_runs IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return ReindexRun{}, err
+	}
+	var running bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM reindex_runs
+			WHERE status = 'running' AND ($1 = '' OR discord_server_id IS NULL OR discord_server_id = $1)
+		)
+	`, run.ServerID).Scan(&running)
+	if err != nil {
+		return ReindexRun{}, err
+	}
+	if running {
+		return ReindexRun{}, ErrReindexRunning
+	}
+
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM documents WHERE `+reindexDocumentsFilter,
+		run.ServerID, run.EmbeddingModel, run.ChunkerVersion).Scan(&run.Total)
+	if err != nil {
+		return ReindexRun{}, fmt.Errorf("failed to count documents: %v", err)
+	}
+	run, err = scanReindexRun(tx.QueryRow(ctx, `
+		INSERT INTO reindex_runs (discord_server_id, embedding_model, chunker_version, total, started_by, worker, heartbeat_at)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		RETURNING `+reindexRunColumns,
+		run.ServerID, run.EmbeddingModel, run.ChunkerVersion, run.Total, run.StartedBy, worker))
+	if err != nil {
+		return ReindexRun{}, fmt.Errorf("failed to create re-index run: %v", err)
+	}
+	return run, tx.Commit(ctx)
+}
+
+func GetReindexRun(ctx context.Context, id int) (ReindexRun, error) {
+	return scanReindexRun(DbPool.QueryRow(ctx, `SELECT `+reindexRunColumns+` FROM reindex_runs WHERE id = $1`, id))
+}
+
+// The server's newest run (of every server if empty), nil if there's none
+func GetLatestReindexRun(ctx context.Context, serverID string) (*ReindexRun, error) {
+	run, err := scanReindexRun(DbPool.QueryRow(ctx, `
+		SELECT `+reindexRunColumns+` FROM reindex_runs
+		WHERE discord_server_id IS NOT DISTINCT FROM NULLIF($1, '')
+		ORDER BY id DESC LIMIT 1
+	`, serverID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Claims running runs whose worker stopped sending heartbeats
+func ClaimStaleReindexRuns(ctx context.Context, worker string, staleAfter time.Duration) ([]ReindexRun, error) {
+	rows, err := DbPool.Query(ctx, `
+		UPDATE reindex_runs SET worker = $1, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE status = 'running' AND (heartbeat_at IS NULL OR heartbeat_at < $2)
+		RETURNING `+reindexRunColumns,
+		worker, time.Now().Add(-staleAfter))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (ReindexRun, error) {
+		return scanReindexRun(row)
+	})
+}
+
+// The next documents of the run, after the last one it finished
+func GetReindexDocuments(ctx context.Context, run ReindexRun, limit int) ([]Document, error) {
+	rows, err := DbPool.Query(ctx, `
+		SELECT id, discord_server_id, message_id, title, source_url, COALESCE(mime_type, ''), COALESCE(text_key, '')
+		FROM documents
+		WHERE id > $4 AND `+reindexDocumentsFilter+`
+		ORDER BY id
+		LIMIT $5
+	`, run.ServerID, run.EmbeddingModel, run.ChunkerVersion, run.LastDocumentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Document, error) {
+		var doc Document
+		err := row.Scan(&doc.ID, &doc.ServerID, &doc.MessageID, &doc.Title, &doc.SourceURL, &doc.MimeType, &doc.TextKey)
+		return doc, err
+	})
+}
+
+// Records a finished document and renews the worker's claim. Returns the run
+// as stored, so the worker sees when it was cancelled.
+func AdvanceReindexRun(ctx context.Context, runID int, documentID int, skipped bool) (ReindexRun, error) {
+	done, skip := 1, 0
+	if skipped {
+		done, skip = 0, 1
+	}
+	return scanReindexRun(DbPool.QueryRow(ctx, `
+		UPDATE reindex_runs
+		SET last_document_id = $2, done = done + $3, skipped = skipped + $4, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+reindexRunColumns,
+		runID, documentID, done, skip))
+}
+
+// Swaps in the run's chunks: the old chunks of every document it re-indexed
+// are deleted and the new ones become searchable, all in one transaction
+func CompleteReindexRun(ctx context.Context, run ReindexRun) error {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE reindex_runs SET status = 'complete', finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'running'
+	`, run.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("re-index run %d isn't running anymore", run.ID)
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE documents SET embedding_model = $2, chunker_version = $3
+		WHERE id IN (SELECT document_id FROM chunks WHERE reindex_run_id = $1)
+	`, run.ID, run.EmbeddingModel, run.ChunkerVersion)
+	if err != nil {
+		return fmt.Errorf("failed to update documents: %v", err)
+	}
+	statements := []string{
+		`DELETE FROM chunks
+		WHERE reindex_run_id IS NULL AND document_id IN (SELECT document_id FROM chunks WHERE reindex_run_id = $1)`,
+		`UPDATE chunks SET reindex_run_id = NULL WHERE reindex_run_id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, run.ID); err != nil {
+			return fmt.Errorf("failed to switch over chunks: %v", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Ends a run as failed or cancelled, dropping the chunks it built
+func StopReindexRun(ctx context.Context, runID int, status string, reason string) error {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, `
+		UPDATE reindex_runs SET status = $2, error = NULLIF($3, ''), finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'running'
+	`, runID, status, reason)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM chunks WHERE reindex_run_id = $1`, runID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
 func UpdateServersLLMConfig(serverID string, company string, model string) error {
 	_, err := DbPool.Exec(context.Background(), `
 		UPDATE joined_servers
@@ -344,6 +1123,280 @@ func UpdateServersLLMConfig(serverID string, company string, model string) error
 	return nil
 }
 
+func GetServerGenerationParams(serverID string) (GenerationParams, error) {
+	redis_key := fmt.Sprintf(`server_%s_llm_params`, serverID)
+	cached, redis_err := RedisClient.Get(context.Background(), redis_key).Result()
+	var params GenerationParams
+
+	err := json.Unmarshal([]byte(cached), &params)
+	if redis_err == nil && err == nil {
+		log.Println("Generation params cache hit")
+		return params, nil
+	}
+
+	log.Printf("Not found in cache: %s", redis_key)
+	err = DbPool.QueryRow(context.Background(), `
+		SELECT llm_temperature, llm_max_tokens, llm_top_p, llm_reasoning_effort
+		FROM joined_servers
+		WHERE discord_server_id = $1
+	`, serverID).Scan(&params.Temperature, &params.MaxTokens, &params.TopP, &params.ReasoningEffort)
+	if err != nil {
+		return GenerationParams{}, err
+	}
+	UpdateJSONToRedis(redis_key, params)
+	return params, nil
+}
+
+func UpdateServerGenerationParams(serverID string, params GenerationParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	_, err := DbPool.Exec(context.Background(), `
+		UPDATE joined_servers
+		SET llm_temperature = $1, llm_max_tokens = $2, llm_top_p = $3, llm_reasoning_effort = $4
+		WHERE discord_server_id = $5`,
+		params.Temperature, params.MaxTokens, params.TopP, params.ReasoningEffort, serverID)
+	if err != nil {
+		return err
+	}
+
+	redis_key := fmt.Sprintf(`server_%s_llm_params`, serverID)
+	if err = UpdateJSONToRedis(redis_key, params); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+func GetKnowledgeMode(serverID string) (string, error) {
+	redis_key := fmt.Sprintf(`server_%s_knowledge_mode`, serverID)
+	mode, err := RedisClient.Get(context.Background(), redis_key).Result()
+	if err == nil {
+		log.Println("Knowledge mode cache hit")
+		return mode, nil
+	}
+
+	log.Printf("Not found in cache: %s", redis_key)
+	err = DbPool.QueryRow(context.Background(), `
+		SELECT knowledge_mode FROM joined_servers WHERE discord_server_id = $1
+	`, serverID).Scan(&mode)
+	if err != nil {
+		return "", err
+	}
+	UpdateStringToRedis(redis_key, mode)
+	return mode, nil
+}
+
+func UpdateKnowledgeMode(serverID string, mode string) error {
+	if !slices.Contains(KnowledgeModes, mode) {
+		return fmt.Errorf("unknown knowledge mode '%s'", mode)
+	}
+	_, err := DbPool.Exec(context.Background(), `
+		UPDATE joined_servers SET knowledge_mode = $1 WHERE discord_server_id = $2
+	`, mode, serverID)
+	if err != nil {
+		return err
+	}
+
+	redis_key := fmt.Sprintf(`server_%s_knowledge_mode`, serverID)
+	if err = UpdateStringToRedis(redis_key, mode); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// Pins one of the server's indexed documents into its knowledge base.
+// Returns false if the server has no such document.
+func AddKnowledgeDocument(ctx context.Context, serverID string, documentID int, addedBy string) (bool, error) {
+	tag, err := DbPool.Exec(ctx, `
+		INSERT INTO knowledge_base (document_id, discord_server_id, added_by)
+		SELECT id, discord_server_id, $3 FROM documents
+		WHERE id = $1 AND discord_server_id = $2 AND status = $4
+		ON CONFLICT DO NOTHING
+	`, documentID, serverID, addedBy, DocumentReady)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		err = DbPool.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM knowledge_base WHERE document_id = $1 AND discord_server_id = $2)
+		`, documentID, serverID).Scan(&exists)
+		return exists, err
+	}
+	return true, nil
+}
+
+// Returns false if the document wasn't in the knowledge base
+func RemoveKnowledgeDocument(ctx context.Context, serverID string, documentID int) (bool, error) {
+	tag, err := DbPool.Exec(ctx, `
+		DELETE FROM knowledge_base WHERE document_id = $1 AND discord_server_id = $2
+	`, documentID, serverID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// The knowledge base's documents, most recently added first
+func GetKnowledgeDocuments(ctx context.Context, serverID string) ([]Document, error) {
+	rows, err := DbPool.Query(ctx, `
+		SELECT d.id, d.channel_id, d.message_id, d.title, d.source_url, d.file_size, d.created_at
+		FROM knowledge_base kb
+		JOIN documents d ON d.id = kb.document_id
+		WHERE kb.discord_server_id = $1
+		ORDER BY kb.added_at DESC
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	return collectDocuments(rows, serverID)
+}
+
+// The server's ready documents whose title contains typed, newest first
+func SearchServerDocuments(ctx context.Context, serverID string, typed string, limit int) ([]Document, error) {
+	rows, err := DbPool.Query(ctx, `
+		SELECT id, channel_id, message_id, title, source_url, file_size, created_at
+		FROM documents
+		WHERE discord_server_id = $1 AND status = $2 AND title ILIKE '%' || $3 || '%'
+		ORDER BY created_at DESC
+		LIMIT $4
+	`, serverID, DocumentReady, typed, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectDocuments(rows, serverID)
+}
+
+// A page of the server's documents, newest first, optionally only from a
+// channel or uploader. Also returns how many match in total.
+func ListServerDocuments(ctx context.Context, serverID string, channelID string, uploaderID string, offset int, limit int) ([]Document, int, error) {
+	var total int
+	err := DbPool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM documents
+		WHERE discord_server_id = $1 AND ($2 = '' OR channel_id = $2) AND ($3 = '' OR uploader_id = $3)
+	`, serverID, channelID, uploaderID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := DbPool.Query(ctx, `
+		SELECT id, channel_id, COALESCE(thread_id, ''), message_id, uploader_id, title, source_url,
+			COALESCE(mime_type, ''), file_size, COALESCE(page_count, 0), status, created_at
+		FROM documents
+		WHERE discord_server_id = $1 AND ($2 = '' OR channel_id = $2) AND ($3 = '' OR uploader_id = $3)
+		ORDER BY created_at DESC, id DESC
+		OFFSET $4 LIMIT $5
+	`, serverID, channelID, uploaderID, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	docs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Document, error) {
+		doc := Document{ServerID: serverID}
+		err := row.Scan(&doc.ID, &doc.ChannelID, &doc.ThreadID, &doc.MessageID, &doc.UploaderID, &doc.Title, &doc.SourceURL,
+			&doc.MimeType, &doc.FileSize, &doc.PageCount, &doc.Status, &doc.CreatedAt)
+		return doc, err
+	})
+	return docs, total, err
+}
+
+// The server's document and how many chunks it has, nil if there's no such document
+func GetServerDocument(ctx context.Context, serverID string, documentID int) (*Document, int, error) {
+	doc := Document{ServerID: serverID}
+	var chunkCount int
+	err := DbPool.QueryRow(ctx, `
+		SELECT d.id, d.channel_id, COALESCE(d.thread_id, ''), d.message_id, d.uploader_id, d.title, d.source_url,
+			COALESCE(d.mime_type, ''), d.file_size, COALESCE(d.page_count, 0), d.status, COALESCE(d.embedding_model, ''), d.created_at,
+			(SELECT COUNT(*) FROM chunks c WHERE c.document_id = d.id AND c.reindex_run_id IS NULL)
+		FROM documents d
+		WHERE d.discord_server_id = $1 AND d.id = $2
+	`, serverID, documentID).Scan(&doc.ID, &doc.ChannelID, &doc.ThreadID, &doc.MessageID, &doc.UploaderID, &doc.Title, &doc.SourceURL,
+		&doc.MimeType, &doc.FileSize, &doc.PageCount, &doc.Status, &doc.EmbeddingModel, &doc.CreatedAt, &chunkCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return &doc, chunkCount, nil
+}
+
+// Removes one document with its chunks, upload record and the answers quoting
+// it. The message's thread link goes too when it was the message's last document.
+func DeleteDocument(ctx context.Context, doc Document) error {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM chunks WHERE document_id = $1`, doc.ID); err != nil {
+		return fmt.Errorf("failed to delete chunks: %v", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM documents WHERE id = $1`, doc.ID); err != nil {
+		return fmt.Errorf("failed to delete document: %v", err)
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM uploaded_files WHERE id = (
+			SELECT id FROM uploaded_files WHERE message_id = $1 AND file_url = $2 LIMIT 1
+		)
+	`, doc.MessageID, doc.SourceURL)
+	if err != nil {
+		return fmt.Errorf("failed to delete upload record: %v", err)
+	}
+	// Other documents of the message stay, so only answers quoting this one go
+	_, err = tx.Exec(ctx, `
+		DELETE FROM answers WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements(sources) source
+			WHERE source->>'message_id' = $1 AND source->>'title' = $2
+		)
+	`, doc.MessageID, doc.Title)
+	if err != nil {
+		return fmt.Errorf("failed to delete answers: %v", err)
+	}
+	var threadIDs []string
+	rows, err := tx.Query(ctx, `
+		DELETE FROM thread_documents
+		WHERE message_id = $1 AND NOT EXISTS (SELECT 1 FROM documents WHERE message_id = $1)
+		RETURNING thread_id
+	`, doc.MessageID)
+	if err != nil {
+		return fmt.Errorf("failed to delete thread links: %v", err)
+	}
+	if threadIDs, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+		return fmt.Errorf("failed to delete thread links: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	for _, threadID := range threadIDs {
+		redis_key := fmt.Sprintf(`thread_%s_documents`, threadID)
+		if err := RedisClient.Del(ctx, redis_key).Err(); err != nil {
+			log.Println(err)
+		}
+	}
+	log.Printf("Deleted document %d (%s)", doc.ID, doc.Title)
+	return nil
+}
+
+func collectDocuments(rows pgx.Rows, serverID string) ([]Document, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Document, error) {
+		doc := Document{ServerID: serverID, Status: DocumentReady}
+		err := row.Scan(&doc.ID, &doc.ChannelID, &doc.MessageID, &doc.Title, &doc.SourceURL, &doc.FileSize, &doc.CreatedAt)
+		return doc, err
+	})
+}
+
+func GetServerOwnerID(serverID string) (string, error) {
+	var ownerID string
+	err := DbPool.QueryRow(context.Background(), `
+		SELECT owner_id FROM joined_servers WHERE discord_server_id = $1
+	`, serverID).Scan(&ownerID)
+	if err != nil {
+		return "", err
+	}
+	return ownerID, nil
+}
+
 func GetUserInfoFromUserID(discordID string) (UserInfo, error) {
 	row := DbPool.QueryRow(context.Background(), `
         SELECT price_id, plan, plan_monthly_start_date, plan_renewal_date, joined_at 
@@ -373,16 +1426,25 @@ func GetUserInfoFromUserID(discordID string) (UserInfo, error) {
 // Map of plan names to their limits
 var planLimitsMap = map[string]PlanLimits{
 	"free": {
-		MaxFileUploads: 10,
-		MaxMessages:    100,
+		MaxFileUploads:    10,
+		MaxMessages:       100,
+		MaxStorageBytes:   100_000_000,
+		MaxFileSize:       10_000_000,
+		OriginalRetention: 30 * 24 * time.Hour,
 	},
 	"Intellicord Basic": {
-		MaxFileUploads: 50,
-		MaxMessages:    500,
+		MaxFileUploads:    50,
+		MaxMessages:       500,
+		MaxStorageBytes:   1_000_000_000,
+		MaxFileSize:       25_000_000,
+		OriginalRetention: 365 * 24 * time.Hour,
 	},
 	"Intellicord Premium": {
-		MaxFileUploads: 500,
-		MaxMessages:    5000,
+		MaxFileUploads:    500,
+		MaxMessages:       5000,
+		MaxStorageBytes:   10_000_000_000,
+		MaxFileSize:       50_000_000,
+		OriginalRetention: 0, // kept as long as the document
 	},
 }
 
@@ -432,7 +1494,7 @@ func UnbanUser(userID string, serverID string) error {
 	return nil
 }
 
-func CheckOwnerLimits(ownerID string) (bool, bool, error) {
+func GetOwnerUsage(ownerID string) (OwnerUsage, error) {
 	cached, redis_err := RedisClient.Get(context.Background(), ownerID).Result()
 	cachedByteArr := []byte(cached)
 	var userInfo UserInfo
@@ -443,7 +1505,7 @@ func CheckOwnerLimits(ownerID string) (bool, bool, error) {
 		userInfo, err = GetUserInfoFromUserID(ownerID)
 		if err != nil {
 			log.Printf("Error getting user info: %v", err)
-			return false, false, err
+			return OwnerUsage{}, err
 		}
 		err = UpdateJSONToRedis(ownerID, userInfo)
 		if err != nil {
@@ -466,7 +1528,7 @@ func CheckOwnerLimits(ownerID string) (bool, bool, error) {
 
 			if err != nil {
 				log.Printf("Error updating free user's billing period: %v", err)
-				return false, false, err
+				return OwnerUsage{}, err
 			}
 
 			userInfo.PlanMonthlyStartDate = newStartDate
@@ -492,7 +1554,7 @@ func CheckOwnerLimits(ownerID string) (bool, bool, error) {
 
 	if err != nil {
 		log.Printf("Error counting file uploads: %v", err)
-		return false, false, err
+		return OwnerUsage{}, err
 	}
 
 	// Count total messages within the current billing period
@@ -506,16 +1568,33 @@ func CheckOwnerLimits(ownerID string) (bool, bool, error) {
 
 	if err != nil {
 		log.Printf("Error counting messages: %v", err)
-		return false, false, err
+		return OwnerUsage{}, err
 	}
-	log.Printf("File Uploads: %d/%d", totalFileUploads, planLimits.MaxFileUploads)
-	log.Printf("Messages: %d/%d", totalMessages, planLimits.MaxMessages)
 
-	// Check if limits are reached
-	fileUploadLimitReached := totalFileUploads >= planLimits.MaxFileUploads
-	messageLimitReached := totalMessages >= planLimits.MaxMessages
+	// Storage counts what's indexed right now, deleting documents frees it up
+	var storedBytes int64
+	err = DbPool.QueryRow(context.Background(), `
+        SELECT COALESCE(SUM(d.file_size), 0)
+        FROM documents d
+        JOIN joined_servers js ON d.discord_server_id = js.discord_server_id
+        WHERE js.owner_id = $1 AND d.status != $2
+    `, ownerID, DocumentFailed).Scan(&storedBytes)
 
-	return fileUploadLimitReached, messageLimitReached, nil
+	if err != nil {
+		log.Printf("Error summing stored bytes: %v", err)
+		return OwnerUsage{}, err
+	}
+	log.Printf("File Uploads: %d/%d", totalFileUploads, planLimits.MaxFileUploads)
+	log.Printf("Messages: %d/%d", totalMessages, planLimits.MaxMessages)
+	log.Printf("Storage: %d/%d bytes", storedBytes, planLimits.MaxStorageBytes)
+
+	return OwnerUsage{
+		Plan:        userInfo.Plan,
+		Limits:      planLimits,
+		FileUploads: totalFileUploads,
+		Messages:    totalMessages,
+		StoredBytes: storedBytes,
+	}, nil
 }
 
 func UpdateStringToRedis(key string, value string) error {
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Applies the migrations in migrations/ that haven't run yet, in file name
+// order, each in its own transaction. postgres_init/setup.sql only runs on an
+// empty database, so schema changes after that have to ship as a migration too.
+func RunMigrations(ctx context.Context) error {
+	_, err := DbPool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := applyMigration(ctx, name); err != nil {
+			return fmt.Errorf("migration %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, name string) error {
+	tx, err := DbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Also keeps a second instance starting at the same time from applying it twice
+	tag, err := tx.Exec(ctx, `INSERT INTO schema_migrations (name) VALUES ($1) ON CONFLICT DO NOTHING`, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	sql, err := migrationFiles.ReadFile(path.Join("migrations", name))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, string(sql)); err != nil {
+		return err
+	}
+	log.Printf("Applied migration %s", name)
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,13 @@
+-- Per-channel overrides of the server's model, persona, retrieval depth and
+-- auto-threading. NULL columns fall back to the server's settings.
+
+CREATE TABLE IF NOT EXISTS channel_settings (
+    channel_id TEXT PRIMARY KEY,
+    discord_server_id TEXT NOT NULL,
+    llm_company TEXT,
+    llm_model TEXT,
+    persona TEXT,
+    retrieval_depth INTEGER,
+    auto_thread BOOLEAN,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+-- Per-server generation parameters, NULL = the provider's default
+
+ALTER TABLE joined_servers ADD COLUMN IF NOT EXISTS llm_temperature DOUBLE PRECISION;
+ALTER TABLE joined_servers ADD COLUMN IF NOT EXISTS llm_max_tokens INTEGER;
+ALTER TABLE joined_servers ADD COLUMN IF NOT EXISTS llm_top_p DOUBLE PRECISION;
+ALTER TABLE joined_servers ADD COLUMN IF NOT EXISTS llm_reasoning_effort TEXT;
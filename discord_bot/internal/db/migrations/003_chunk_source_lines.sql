@@ -0,0 +1,5 @@
+-- File path and line range of chunks from source files and archives
+
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS source_path TEXT;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS start_line INTEGER;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS end_line INTEGER;
@@ -0,0 +1,11 @@
+-- Documents a bot thread answers from besides its starter message's, like
+-- files posted in the thread
+
+CREATE TABLE IF NOT EXISTS thread_documents (
+    thread_id TEXT NOT NULL,
+    message_id TEXT NOT NULL, -- chunks of the message's documents are stored under this ID
+    discord_server_id TEXT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (thread_id, message_id),
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
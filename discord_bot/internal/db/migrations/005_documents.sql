@@ -0,0 +1,83 @@
+-- One row per indexed file or page, chunks point to their document
+
+CREATE TABLE IF NOT EXISTS documents (
+    id SERIAL PRIMARY KEY,
+    discord_server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    thread_id TEXT,
+    message_id TEXT NOT NULL,
+    uploader_id TEXT NOT NULL,
+    title TEXT NOT NULL,
+    source_url TEXT NOT NULL,
+    content_hash TEXT,
+    mime_type TEXT,
+    file_size BIGINT NOT NULL DEFAULT 0,
+    page_count INTEGER,
+    status TEXT NOT NULL DEFAULT 'processing',
+    embedding_model TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS documents_message_id_idx ON documents (message_id);
+CREATE INDEX IF NOT EXISTS documents_server_id_idx ON documents (discord_server_id);
+
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS document_id INTEGER REFERENCES documents(id) ON DELETE CASCADE;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS ordinal INTEGER;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS page INTEGER;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS section TEXT;
+CREATE INDEX IF NOT EXISTS chunks_document_id_idx ON chunks (document_id);
+CREATE INDEX IF NOT EXISTS chunks_message_id_idx ON chunks (message_id);
+
+-- Backfill: every (message, file) pair in chunks becomes a document. Uploader,
+-- channel and size come from the matching uploaded_files row when there is one.
+-- Thread IDs are only known for documents added through thread_documents.
+INSERT INTO documents (
+    discord_server_id, channel_id, thread_id, message_id, uploader_id,
+    title, source_url, file_size, status, created_at
+)
+SELECT
+    c.discord_server_id,
+    COALESCE(uf.channel_id, ''),
+    td.thread_id,
+    c.message_id,
+    COALESCE(uf.uploader_id, ''),
+    COALESCE(c.title, ''),
+    COALESCE(c.doc_url, ''),
+    COALESCE(uf.file_size, 0),
+    'ready',
+    COALESCE(uf.uploaded_at, CURRENT_TIMESTAMP)
+FROM (
+    SELECT DISTINCT message_id, discord_server_id, title, doc_url
+    FROM chunks
+    WHERE document_id IS NULL AND message_id IS NOT NULL
+) c
+JOIN joined_servers js ON js.discord_server_id = c.discord_server_id
+LEFT JOIN LATERAL (
+    SELECT channel_id, uploader_id, file_size, uploaded_at
+    FROM uploaded_files u
+    WHERE u.discord_server_id = c.discord_server_id AND u.file_url = c.doc_url
+    ORDER BY u.uploaded_at DESC
+    LIMIT 1
+) uf ON true
+LEFT JOIN LATERAL (
+    SELECT thread_id FROM thread_documents t WHERE t.message_id = c.message_id LIMIT 1
+) td ON true;
+
+UPDATE chunks c
+SET document_id = d.id
+FROM documents d
+WHERE c.document_id IS NULL
+    AND d.message_id = c.message_id
+    AND d.discord_server_id = c.discord_server_id
+    AND d.title = COALESCE(c.title, '')
+    AND d.source_url = COALESCE(c.doc_url, '');
+
+-- Insertion order is the best guess for the original chunk order
+UPDATE chunks c
+SET ordinal = numbered.ordinal
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY document_id ORDER BY id) - 1 AS ordinal
+    FROM chunks
+    WHERE document_id IS NOT NULL AND ordinal IS NULL
+) numbered
+WHERE c.id = numbered.id;
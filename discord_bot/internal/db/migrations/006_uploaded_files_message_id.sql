@@ -0,0 +1,12 @@
+-- Lets an upload's row be removed with the rest of its data when the message
+-- is deleted. Older rows are matched to their document by URL.
+
+ALTER TABLE uploaded_files ADD COLUMN IF NOT EXISTS message_id TEXT;
+CREATE INDEX IF NOT EXISTS uploaded_files_message_id_idx ON uploaded_files (message_id);
+
+UPDATE uploaded_files uf
+SET message_id = d.message_id
+FROM documents d
+WHERE uf.message_id IS NULL
+    AND d.discord_server_id = uf.discord_server_id
+    AND d.source_url = uf.file_url;
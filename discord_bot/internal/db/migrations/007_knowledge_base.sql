@@ -0,0 +1,12 @@
+-- Documents pinned into a server's knowledge base, used by /ask and mentions
+
+ALTER TABLE joined_servers ADD COLUMN IF NOT EXISTS knowledge_mode TEXT NOT NULL DEFAULT 'knowledge_and_general';
+
+CREATE TABLE IF NOT EXISTS knowledge_base (
+    document_id INTEGER PRIMARY KEY REFERENCES documents(id) ON DELETE CASCADE,
+    discord_server_id TEXT NOT NULL,
+    added_by TEXT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS knowledge_base_server_id_idx ON knowledge_base (discord_server_id);
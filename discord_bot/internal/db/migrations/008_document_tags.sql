@@ -0,0 +1,3 @@
+-- Forum tags of the post a document was posted in
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,13 @@
+-- Channels whose message history is indexed into the knowledge base. Each
+-- conversation window is a chunk of the channel's history document, stored
+-- under the ID of the window's first message.
+
+CREATE TABLE IF NOT EXISTS channel_history (
+    channel_id TEXT PRIMARY KEY,
+    discord_server_id TEXT NOT NULL,
+    document_id INTEGER NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    last_message_id TEXT, -- newest message indexed, NULL until the backfill is done
+    enabled_by TEXT NOT NULL,
+    enabled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
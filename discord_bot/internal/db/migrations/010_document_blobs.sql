@@ -0,0 +1,12 @@
+-- Original files and extracted text kept in blob storage, keyed by content
+-- hash. Originals expire per plan, extracted text lives as long as a document
+-- uses it. Blobs no document references anymore are deleted by the sweep.
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS original_key TEXT;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS text_key TEXT;
+
+CREATE TABLE IF NOT EXISTS blobs (
+    key TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
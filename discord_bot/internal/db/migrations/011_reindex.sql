@@ -0,0 +1,27 @@
+-- Re-indexing documents into a new embedding model or chunker. A run's chunks
+-- are hidden from search until it completes and they replace the old ones.
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS chunker_version INTEGER;
+UPDATE documents SET chunker_version = 1 WHERE chunker_version IS NULL;
+
+CREATE TABLE IF NOT EXISTS reindex_runs (
+    id SERIAL PRIMARY KEY,
+    discord_server_id TEXT, -- NULL = every server
+    embedding_model TEXT NOT NULL,
+    chunker_version INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'running', -- running, complete, failed or cancelled
+    total INTEGER NOT NULL DEFAULT 0,
+    done INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    last_document_id INTEGER NOT NULL DEFAULT 0, -- documents go in ID order, a resumed run continues after this
+    started_by TEXT NOT NULL,
+    worker TEXT, -- process working on the run, another one takes over when heartbeat_at goes stale
+    heartbeat_at TIMESTAMP,
+    error TEXT,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS reindex_run_id INTEGER REFERENCES reindex_runs(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS chunks_reindex_run_id_idx ON chunks (reindex_run_id) WHERE reindex_run_id IS NOT NULL;
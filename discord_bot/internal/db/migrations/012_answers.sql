@@ -0,0 +1,30 @@
+-- Answers the bot gave in threads, with the model and retrieved sources, and
+-- the 👍/👎 feedback on them. question_message_id is the message the question
+-- was read from.
+
+CREATE TABLE IF NOT EXISTS answers (
+    id SERIAL PRIMARY KEY,
+    answer_message_id TEXT NOT NULL, -- last message of the answer, it has the buttons
+    message_ids TEXT[] NOT NULL, -- every message the answer was sent as
+    question_message_id TEXT NOT NULL,
+    question_channel_id TEXT NOT NULL,
+    asker_id TEXT NOT NULL,
+    discord_server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    llm_company TEXT NOT NULL,
+    llm_model TEXT NOT NULL,
+    retrieval_limit INTEGER NOT NULL,
+    sources JSONB NOT NULL DEFAULT '[]', -- retrieved chunks as they were when answering
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS answers_answer_message_id_idx ON answers (answer_message_id);
+
+CREATE TABLE IF NOT EXISTS answer_feedback (
+    answer_id INTEGER NOT NULL REFERENCES answers(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    rating SMALLINT NOT NULL, -- 1 or -1
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (answer_id, user_id)
+);
@@ -0,0 +1,5 @@
+-- The ingestion job that created a document, so a retried job reuses the
+-- document and its uploaded_files row instead of adding new ones
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS job_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS documents_job_id_idx ON documents (job_id) WHERE job_id IS NOT NULL;
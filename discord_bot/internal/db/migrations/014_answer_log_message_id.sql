@@ -0,0 +1,10 @@
+-- The answer's entry in message_logs. That's the question's message for
+-- messages, but the interaction for context menu questions and regenerated
+-- answers. /ask isn't logged, so it's NULL there.
+
+ALTER TABLE answers ADD COLUMN IF NOT EXISTS log_message_id TEXT;
+
+UPDATE answers a
+SET log_message_id = a.question_message_id
+WHERE a.log_message_id IS NULL
+    AND EXISTS (SELECT 1 FROM message_logs ml WHERE ml.message_id = a.question_message_id);
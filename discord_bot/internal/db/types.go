@@ -1,6 +1,11 @@
 package db
 
-import "time"
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
 
 type JoinedServersInfo struct {
 	ID              int    `json:"id"`
@@ -23,6 +28,115 @@ type FileInformation struct {
 	DiscordServerID string `json:"discord_server_id"`
 }
 
+const (
+	DocumentProcessing = "processing"
+	DocumentReady      = "ready"
+	DocumentFailed     = "failed"
+)
+
+// Documents of indexed channels, each chunk is one conversation
+const HistoryMimeType = "text/x-discord-history"
+
+const (
+	ReindexRunning   = "running"
+	ReindexComplete  = "complete"
+	ReindexFailed    = "failed"
+	ReindexCancelled = "cancelled"
+)
+
+// What /ask and mentions answer from
+const (
+	KnowledgeOnly       = "knowledge_only"
+	KnowledgeAndGeneral = "knowledge_and_general"
+	GeneralOnly         = "general_only"
+)
+
+var KnowledgeModes = []string{KnowledgeOnly, KnowledgeAndGeneral, GeneralOnly}
+
+// An indexed file or web page, its chunks reference it by ID
+type Document struct {
+	ID             int       `json:"id"`
+	ServerID       string    `json:"discord_server_id"`
+	ChannelID      string    `json:"channel_id"`
+	ThreadID       string    `json:"thread_id,omitempty"`
+	MessageID      string    `json:"message_id"` // chunks are searched by this ID
+	UploaderID     string    `json:"uploader_id"`
+	Title          string    `json:"title"`
+	SourceURL      string    `json:"source_url"`
+	ContentHash    string    `json:"content_hash,omitempty"` // sha256 of the extracted text
+	MimeType       string    `json:"mime_type,omitempty"`
+	FileSize       int       `json:"file_size"`
+	PageCount      int       `json:"page_count,omitempty"`
+	Status         string    `json:"status"`
+	EmbeddingModel string    `json:"embedding_model,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`         // forum tags of the post it was posted in
+	OriginalKey    string    `json:"original_key,omitempty"` // blob of the original file, empty once expired
+	TextKey        string    `json:"text_key,omitempty"`     // blob of the extracted text
+	ChunkerVersion int       `json:"chunker_version,omitempty"`
+	JobID          string    `json:"job_id,omitempty"` // ingestion job that created it, retries reuse the document
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Re-chunks and re-embeds documents into the current embedding model and
+// chunker. New chunks are written next to the old ones, hidden from search,
+// and replace them in one transaction once every document is done.
+type ReindexRun struct {
+	ID             int       `json:"id"`
+	ServerID       string    `json:"discord_server_id,omitempty"` // empty = every server
+	EmbeddingModel string    `json:"embedding_model"`
+	ChunkerVersion int       `json:"chunker_version"`
+	Status         string    `json:"status"`
+	Total          int       `json:"total"`
+	Done           int       `json:"done"`
+	Skipped        int       `json:"skipped"`          // no stored text and the URL expired
+	LastDocumentID int       `json:"last_document_id"` // documents go in ID order, a resumed run continues after this
+	StartedBy      string    `json:"started_by"`
+	Error          string    `json:"error,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+}
+
+// A channel opted in to having its message history indexed
+type ChannelHistory struct {
+	ChannelID     string `json:"channel_id"`
+	ServerID      string `json:"discord_server_id"`
+	DocumentID    int    `json:"document_id"`
+	LastMessageID string `json:"last_message_id,omitempty"` // empty until the backfill is done
+}
+
+// An answer the bot gave in a thread, with what it was generated from
+type Answer struct {
+	ID                int            `json:"id"`
+	AnswerMessageID   string         `json:"answer_message_id"` // last message of the answer, it has the buttons
+	MessageIDs        []string       `json:"message_ids"`
+	QuestionMessageID string         `json:"question_message_id"`
+	QuestionChannelID string         `json:"question_channel_id"`
+	LogMessageID      string         `json:"log_message_id,omitempty"` // its entry in message_logs, empty for /ask
+	AskerID           string         `json:"asker_id"`
+	ServerID          string         `json:"discord_server_id"`
+	ChannelID         string         `json:"channel_id"`
+	Company           string         `json:"llm_company"`
+	Model             string         `json:"llm_model"`
+	RetrievalLimit    int            `json:"retrieval_limit"`
+	Sources           []AnswerSource `json:"sources"`
+}
+
+// A retrieved chunk as it was when the answer was generated
+type AnswerSource struct {
+	MessageID string  `json:"message_id,omitempty"` // the chunk's, answers go when it's deleted
+	Title     string  `json:"title"`
+	Location  string  `json:"location,omitempty"`
+	Excerpt   string  `json:"excerpt"`
+	Distance  float32 `json:"distance"`
+}
+
+// 👍/👎 counts of a model's answers
+type FeedbackStats struct {
+	Company  string `json:"llm_company"`
+	Model    string `json:"llm_model"`
+	Positive int    `json:"positive"`
+	Negative int    `json:"negative"`
+}
+
 type UserInfo struct {
 	PriceID              string    `json:"price_id"`
 	Plan                 string    `json:"plan"`
@@ -32,6 +146,95 @@ type UserInfo struct {
 }
 
 type PlanLimits struct {
-	MaxFileUploads int
-	MaxMessages    int
+	MaxFileUploads    int
+	MaxMessages       int
+	MaxStorageBytes   int64 // all documents currently indexed across the owner's servers
+	MaxFileSize       int64
+	OriginalRetention time.Duration // how long original files stay in blob storage, 0 = as long as the document
+}
+
+// An owner's usage in the current billing period, against their plan's limits
+type OwnerUsage struct {
+	Plan        string
+	Limits      PlanLimits
+	FileUploads int
+	Messages    int
+	StoredBytes int64
+}
+
+func (u OwnerUsage) FileUploadLimitReached() bool {
+	return u.FileUploads >= u.Limits.MaxFileUploads
+}
+
+func (u OwnerUsage) MessageLimitReached() bool {
+	return u.Messages >= u.Limits.MaxMessages
+}
+
+func (u OwnerUsage) Summary() string {
+	return fmt.Sprintf("%d/%d files this month, %s of %s stored",
+		u.FileUploads, u.Limits.MaxFileUploads, FormatBytes(u.StoredBytes), FormatBytes(u.Limits.MaxStorageBytes))
+}
+
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1_000_000_000:
+		return fmt.Sprintf("%.1f GB", float64(n)/1_000_000_000)
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1f MB", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1f KB", float64(n)/1_000)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Per-channel overrides, nil fields fall back to the server's settings
+type ChannelSettings struct {
+	LLMCompany     *string `json:"llm_company"`
+	LLMModel       *string `json:"llm_model"`
+	Persona        *string `json:"persona"`
+	RetrievalDepth *int    `json:"retrieval_depth"`
+	AutoThread     *bool   `json:"auto_thread"`
+}
+
+type LLMConfig struct {
+	Company        string           `json:"company"`
+	Model          string           `json:"model"`
+	Persona        string           `json:"persona"`
+	RetrievalDepth int              `json:"retrieval_depth"`
+	AutoThread     bool             `json:"auto_thread"`
+	Params         GenerationParams `json:"params"`
+}
+
+// Per-server sampling settings, nil fields use the provider's default
+type GenerationParams struct {
+	Temperature     *float64 `json:"temperature"`
+	MaxTokens       *int     `json:"max_tokens"`
+	TopP            *float64 `json:"top_p"`
+	ReasoningEffort *string  `json:"reasoning_effort"`
+}
+
+var ReasoningEfforts = []string{"minimal", "low", "medium", "high"}
+
+func (p GenerationParams) Validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if p.MaxTokens != nil && (*p.MaxTokens < 1 || *p.MaxTokens > 32768) {
+		return fmt.Errorf("max tokens must be between 1 and 32768")
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("top p must be greater than 0 and at most 1")
+	}
+	if p.ReasoningEffort != nil && !slices.Contains(ReasoningEfforts, *p.ReasoningEffort) {
+		return fmt.Errorf("reasoning effort must be one of: %s", strings.Join(ReasoningEfforts, ", "))
+	}
+	return nil
+}
+
+// Number of chunks to retrieve, defaults to one more than the number of attachments
+func (c LLMConfig) RetrievalLimit(numOfAttachments int) int {
+	if c.RetrievalDepth > 0 {
+		return c.RetrievalDepth
+	}
+	return numOfAttachments + 1
 }
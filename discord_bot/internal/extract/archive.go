@@ -0,0 +1,174 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MAX_ARCHIVE_ENTRIES   = 5000       // entries looked at, including skipped ones
+	MAX_ARCHIVE_FILES     = 1000       // files actually indexed
+	MAX_ARCHIVE_FILE_SIZE = 1_000_000  // larger files are generated code or data, not source
+	MAX_UNPACKED_SIZE     = 50_000_000 // total across all indexed files
+	MAX_COMPRESSION_RATIO = 100        // anything higher is almost certainly a zip bomb
+)
+
+var ErrArchiveLimit = errors.New("archive exceeds unpacking limits")
+
+// A text file from a source upload or archive
+type SourceFile struct {
+	Path    string
+	Content string
+}
+
+// Dependency and build output directories, indexing them drowns out the project's own code
+var skippedArchiveDirs = []string{
+	".git", "node_modules", "vendor", "__pycache__", ".venv", "venv", "dist", "build", "target", ".idea", ".vscode",
+}
+
+var archiveTextExtensions = []string{".md", ".markdown", ".txt", ".rst", ".json", ".csv", ".mod", ".cfg", ".env.example"}
+
+func isArchive(mimeType string) bool {
+	return mimeType == "application/zip" || mimeType == "application/gzip" || mimeType == "application/x-tar"
+}
+
+// Unpacks the source and text files in a zip, tar or tar.gz archive. Sizes in
+// the archive headers aren't trusted, every read is limited.
+func unpackArchive(data []byte, mimeType string) ([]SourceFile, error) {
+	switch mimeType {
+	case "application/zip":
+		return unpackZip(data)
+	case "application/gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip: %v", err)
+		}
+		defer gz.Close()
+		return unpackTar(gz, len(data))
+	case "application/x-tar":
+		return unpackTar(bytes.NewReader(data), len(data))
+	}
+	return nil, ErrUnsupportedType
+}
+
+func unpackZip(data []byte) ([]SourceFile, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip: %v", err)
+	}
+	if len(reader.File) > MAX_ARCHIVE_ENTRIES {
+		return nil, fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, MAX_ARCHIVE_ENTRIES)
+	}
+
+	var unpacker archiveUnpacker
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() || !f.Mode().IsRegular() || !shouldIndexArchivePath(f.Name) {
+			continue
+		}
+		if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > MAX_COMPRESSION_RATIO {
+			return nil, fmt.Errorf("%w: '%s' is compressed too much", ErrArchiveLimit, f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open '%s': %v", f.Name, err)
+		}
+		err = unpacker.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return unpacker.files, nil
+}
+
+func unpackTar(r io.Reader, compressedSize int) ([]SourceFile, error) {
+	// The whole decompressed stream counts, skipped entries included
+	limited := io.LimitReader(r, int64(compressedSize)*MAX_COMPRESSION_RATIO+MAX_UNPACKED_SIZE)
+	reader := tar.NewReader(limited)
+	var unpacker archiveUnpacker
+	for entries := 0; ; entries++ {
+		if entries >= MAX_ARCHIVE_ENTRIES {
+			return nil, fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, MAX_ARCHIVE_ENTRIES)
+		}
+		header, err := reader.Next()
+		if err == io.EOF {
+			return unpacker.files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg || !shouldIndexArchivePath(header.Name) {
+			continue
+		}
+		if err := unpacker.add(header.Name, reader); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type archiveUnpacker struct {
+	files []SourceFile
+	total int
+}
+
+func (u *archiveUnpacker) add(name string, r io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(r, MAX_ARCHIVE_FILE_SIZE+1))
+	if err != nil {
+		return fmt.Errorf("failed to read '%s': %v", name, err)
+	}
+	if len(data) > MAX_ARCHIVE_FILE_SIZE || !utf8.Valid(data) || bytes.IndexByte(data, 0) != -1 {
+		return nil // generated, minified or binary
+	}
+	u.total += len(data)
+	if u.total > MAX_UNPACKED_SIZE {
+		return fmt.Errorf("%w: more than %d bytes unpacked", ErrArchiveLimit, MAX_UNPACKED_SIZE)
+	}
+	if len(u.files) == MAX_ARCHIVE_FILES {
+		return fmt.Errorf("%w: more than %d files", ErrArchiveLimit, MAX_ARCHIVE_FILES)
+	}
+	u.files = append(u.files, SourceFile{Path: cleanArchivePath(name), Content: string(data)})
+	return nil
+}
+
+func shouldIndexArchivePath(name string) bool {
+	clean := cleanArchivePath(name)
+	if clean == "" {
+		return false
+	}
+	for _, dir := range strings.Split(path.Dir(clean), "/") {
+		if slices.Contains(skippedArchiveDirs, dir) {
+			return false
+		}
+	}
+	base := path.Base(clean)
+	if strings.HasPrefix(base, "._") { // macOS resource forks
+		return false
+	}
+	ext := strings.ToLower(path.Ext(base))
+	return slices.Contains(sourceCodeExtensions, ext) || slices.Contains(archiveTextExtensions, ext) ||
+		base == "Dockerfile" || base == "Makefile"
+}
+
+// Relative path without "..", leading slashes or "./"
+func cleanArchivePath(name string) string {
+	clean := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	return strings.TrimPrefix(clean, "/")
+}
+
+// Text of all files, each under a header with its path
+func joinSourceFiles(files []SourceFile) string {
+	var text strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&text, "// File: %s\n%s\n\n", f.Path, f.Content)
+	}
+	return text.String()
+}
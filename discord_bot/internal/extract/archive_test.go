@@ -0,0 +1,135 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name    string
+	content string
+}
+
+func zipArchive(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		f, err := w.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(e.content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for _, e := range entries {
+		err := w.WriteHeader(&tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// n small source files
+func sourceFiles(n int) []archiveEntry {
+	entries := make([]archiveEntry, n)
+	for i := range entries {
+		entries[i] = archiveEntry{name: fmt.Sprintf("pkg/file%d.go", i), content: "package pkg\n"}
+	}
+	return entries
+}
+
+func TestUnpackArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "repo/main.go", content: "package main\n"},
+		{name: "repo/README.md", content: "# Repo\n"},
+		{name: "repo/node_modules/lib/index.js", content: "module.exports = {}\n"},
+		{name: "repo/logo.png", content: "\x89PNG"},
+		{name: "repo/data.go", content: "package main\x00"},
+		{name: "../../etc/cron.sh", content: "echo hi\n"},
+	}
+	want := []string{"repo/main.go", "repo/README.md", "etc/cron.sh"}
+
+	for mimeType, data := range map[string][]byte{
+		"application/zip":  zipArchive(t, entries),
+		"application/gzip": tarGzArchive(t, entries),
+	} {
+		files, err := unpackArchive(data, mimeType)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mimeType, err)
+		}
+		var paths []string
+		for _, f := range files {
+			paths = append(paths, f.Path)
+		}
+		if strings.Join(paths, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: unpacked %v, expected %v", mimeType, paths, want)
+		}
+	}
+}
+
+func TestUnpackArchiveLimits(t *testing.T) {
+	// Skipped entries count towards the entry limit too
+	tooManyEntries := make([]archiveEntry, MAX_ARCHIVE_ENTRIES+1)
+	for i := range tooManyEntries {
+		tooManyEntries[i] = archiveEntry{name: fmt.Sprintf("img/%d.png", i)}
+	}
+	// Each file is under the per-file limit, together they're over the total
+	tooLarge := make([]archiveEntry, MAX_UNPACKED_SIZE/MAX_ARCHIVE_FILE_SIZE+1)
+	for i := range tooLarge {
+		tooLarge[i] = archiveEntry{name: fmt.Sprintf("data/%d.txt", i), content: strings.Repeat("a", MAX_ARCHIVE_FILE_SIZE)}
+	}
+	bomb := []archiveEntry{{name: "bomb.txt", content: strings.Repeat("0", 10_000_000)}}
+
+	tests := []struct {
+		name     string
+		mimeType string
+		data     []byte
+	}{
+		{name: "zip entries", mimeType: "application/zip", data: zipArchive(t, tooManyEntries)},
+		{name: "tar entries", mimeType: "application/gzip", data: tarGzArchive(t, tooManyEntries)},
+		{name: "zip files", mimeType: "application/zip", data: zipArchive(t, sourceFiles(MAX_ARCHIVE_FILES+1))},
+		{name: "tar files", mimeType: "application/gzip", data: tarGzArchive(t, sourceFiles(MAX_ARCHIVE_FILES+1))},
+		{name: "tar unpacked size", mimeType: "application/gzip", data: tarGzArchive(t, tooLarge)},
+		{name: "zip compression ratio", mimeType: "application/zip", data: zipArchive(t, bomb)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := unpackArchive(tt.data, tt.mimeType); !errors.Is(err, ErrArchiveLimit) {
+				t.Fatalf("expected error '%v', got '%v'", ErrArchiveLimit, err)
+			}
+		})
+	}
+}
+
+func TestUnpackArchiveAtLimits(t *testing.T) {
+	files, err := unpackArchive(zipArchive(t, sourceFiles(MAX_ARCHIVE_FILES)), "application/zip")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != MAX_ARCHIVE_FILES {
+		t.Errorf("unpacked %d files, expected %d", len(files), MAX_ARCHIVE_FILES)
+	}
+}
@@ -0,0 +1,260 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_FILE_SIZE    = 50_000_000 // 50mb, same limit as parser_api
+	DOWNLOAD_TIMEOUT = 2 * time.Minute
+)
+
+var downloadClient = &http.Client{Timeout: DOWNLOAD_TIMEOUT}
+
+var (
+	ErrUnsupportedType = errors.New("unsupported file type")
+	ErrTooLarge        = errors.New("file too large")
+)
+
+// A downloaded file and its sniffed type
+type Source struct {
+	URL      string
+	Filename string
+	Data     []byte
+	MimeType string
+}
+
+type Extractor interface {
+	Extract(ctx context.Context, src Source) (string, error)
+}
+
+// Implemented by extractors that know where in the document the text came from
+type SegmentExtractor interface {
+	ExtractSegments(ctx context.Context, src Source) (segments []Segment, pageCount int, err error)
+}
+
+// Part of a document's text and its location, for citing pages and sheets in answers
+type Segment struct {
+	Page        int      `json:"page"` // 1-based, 0 when unknown
+	Sheet       string   `json:"sheet"`
+	HeadingPath []string `json:"heading_path"`
+	Text        string   `json:"text"`
+}
+
+type Result struct {
+	Text      string
+	Size      int
+	MimeType  string
+	PageCount int
+	Segments  []Segment    // set when the extractor knows pages, sheets or headings
+	Files     []SourceFile // set for source files and archives, chunked by code structure
+	Data      []byte       `json:"-"` // the downloaded file, kept in blob storage
+}
+
+var sourceCodeExtensions = []string{
+	".go", ".py", ".js", ".jsx", ".ts", ".tsx", ".java", ".kt", ".c", ".h", ".cpp", ".hpp", ".cs",
+	".rs", ".rb", ".php", ".swift", ".sh", ".sql", ".yaml", ".yml", ".toml", ".ini", ".xml", ".css",
+	".scala", ".lua", ".dart", ".vue", ".svelte", ".proto", ".tf",
+}
+
+// Downloads the file, sniffs its type and extracts the text natively when possible,
+// falling back to parser_api for pdf, docx, xlsx and epub
+func FromURL(ctx context.Context, url string, filename string) (Result, error) {
+	data, err := download(ctx, url)
+	if err != nil {
+		return Result{}, err
+	}
+
+	src := Source{
+		URL:      url,
+		Filename: filename,
+		Data:     data,
+		MimeType: SniffMimeType(data, filename),
+	}
+	if isArchive(src.MimeType) {
+		files, err := unpackArchive(data, src.MimeType)
+		if err != nil {
+			return Result{}, err
+		}
+		if len(files) == 0 {
+			return Result{}, ErrUnsupportedType
+		}
+		return Result{Text: joinSourceFiles(files), Size: len(data), MimeType: src.MimeType, Files: files, Data: data}, nil
+	}
+
+	extractor, err := extractorFor(src.MimeType)
+	if err != nil {
+		return Result{}, err
+	}
+	if segmenter, ok := extractor.(SegmentExtractor); ok {
+		segments, pageCount, err := segmenter.ExtractSegments(ctx, src)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{
+			Text:      joinSegments(segments),
+			Size:      len(data),
+			MimeType:  src.MimeType,
+			PageCount: pageCount,
+			Segments:  segments,
+			Data:      data,
+		}, nil
+	}
+	text, err := extractor.Extract(ctx, src)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Text: text, Size: len(data), MimeType: src.MimeType, Data: data}
+	if src.MimeType == "text/x-source" {
+		result.Files = []SourceFile{{Path: filename, Content: text}}
+	}
+	return result, nil
+}
+
+func extractorFor(mimeType string) (Extractor, error) {
+	switch mimeType {
+	case "text/plain", "text/markdown", "text/x-source":
+		return TextExtractor{}, nil
+	case "text/csv":
+		return CSVExtractor{}, nil
+	case "application/json":
+		return JSONExtractor{}, nil
+	case "text/html":
+		return HTMLExtractor{}, nil
+	case "application/pdf", "application/epub+zip",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
+		return NewParserClient(os.Getenv("PARSER_API_URL")), nil
+	}
+	return nil, ErrUnsupportedType
+}
+
+func joinSegments(segments []Segment) string {
+	var texts []string
+	for _, segment := range segments {
+		texts = append(texts, segment.Text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Detects the file type from its content. The file name is only used to tell
+// apart formats that look the same, like csv and plain text.
+func SniffMimeType(data []byte, filename string) string {
+	detected := http.DetectContentType(data)
+	mimeType, _, _ := strings.Cut(detected, ";")
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	switch {
+	case mimeType == "application/pdf":
+		return mimeType
+	case mimeType == "application/zip":
+		return sniffZipContainer(data)
+	case mimeType == "application/x-gzip":
+		return "application/gzip" // only tar.gz is supported
+	case mimeType == "application/octet-stream" && ext == ".tar":
+		return "application/x-tar"
+	case mimeType == "text/html":
+		return mimeType
+	case strings.HasPrefix(mimeType, "text/"):
+		trimmed := bytes.TrimSpace(data)
+		if (bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("["))) && json.Valid(trimmed) {
+			return "application/json"
+		}
+		switch {
+		case ext == ".csv":
+			return "text/csv"
+		case ext == ".md" || ext == ".markdown":
+			return "text/markdown"
+		case slices.Contains(sourceCodeExtensions, ext):
+			return "text/x-source"
+		}
+		return "text/plain"
+	}
+	return mimeType
+}
+
+// docx, xlsx, epub and other office formats are all zip files, look at what's
+// inside. Office files that can't be parsed keep their own type, so they're
+// rejected instead of unpacked as source archives.
+func sniffZipContainer(data []byte) string {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "application/zip"
+	}
+	ooxml := false
+	for _, f := range reader.File {
+		switch {
+		case f.Name == "mimetype":
+			// epub and OpenDocument files start with their type
+			return containerMimeType(f)
+		case strings.HasPrefix(f.Name, "word/"):
+			return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case strings.HasPrefix(f.Name, "xl/"):
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		case f.Name == "[Content_Types].xml":
+			ooxml = true
+		}
+	}
+	if ooxml {
+		return "application/octet-stream"
+	}
+	return "application/zip"
+}
+
+func containerMimeType(f *zip.File) string {
+	rc, err := f.Open()
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(io.LimitReader(rc, 100))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	mimeType := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(mimeType, "application/") {
+		return "application/octet-stream"
+	}
+	return mimeType
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > MAX_FILE_SIZE {
+		return nil, ErrTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MAX_FILE_SIZE+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if len(data) > MAX_FILE_SIZE {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
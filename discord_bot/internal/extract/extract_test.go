@@ -0,0 +1,58 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSniffMimeType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		filename string
+		want     string
+	}{
+		{name: "docx", data: zipArchive(t, []archiveEntry{{"[Content_Types].xml", "<Types/>"}, {"word/document.xml", "<w:document/>"}}), filename: "a.docx",
+			want: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "xlsx", data: zipArchive(t, []archiveEntry{{"[Content_Types].xml", "<Types/>"}, {"xl/workbook.xml", "<workbook/>"}}), filename: "a.xlsx",
+			want: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{name: "pptx", data: zipArchive(t, []archiveEntry{{"[Content_Types].xml", "<Types/>"}, {"ppt/presentation.xml", "<p:presentation/>"}}), filename: "a.pptx",
+			want: "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+		{name: "other ooxml", data: zipArchive(t, []archiveEntry{{"[Content_Types].xml", "<Types/>"}, {"visio/document.xml", "<VisioDocument/>"}}), filename: "a.vsdx",
+			want: "application/octet-stream"},
+		{name: "epub", data: zipArchive(t, []archiveEntry{{"mimetype", "application/epub+zip"}, {"OEBPS/content.opf", "<package/>"}}), filename: "a.epub",
+			want: "application/epub+zip"},
+		{name: "odt", data: zipArchive(t, []archiveEntry{{"mimetype", "application/vnd.oasis.opendocument.text"}, {"content.xml", "<office:document-content/>"}}), filename: "a.odt",
+			want: "application/vnd.oasis.opendocument.text"},
+		{name: "source archive", data: zipArchive(t, sourceFiles(2)), filename: "src.zip", want: "application/zip"},
+		{name: "csv", data: []byte("a,b\n1,2\n"), filename: "data.csv", want: "text/csv"},
+		{name: "json", data: []byte(`{"a": 1}`), filename: "data.txt", want: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SniffMimeType(tt.data, tt.filename); got != tt.want {
+				t.Errorf("SniffMimeType() = %s, expected %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromURLUnsupportedContainer(t *testing.T) {
+	containers := map[string][]byte{
+		"/slides.pptx": zipArchive(t, []archiveEntry{{"[Content_Types].xml", "<Types/>"}, {"ppt/slides/slide1.xml", "<p:sld/>"}}),
+		"/notes.odt":   zipArchive(t, []archiveEntry{{"mimetype", "application/vnd.oasis.opendocument.text"}, {"content.xml", "<office:document-content/>"}}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(containers[r.URL.Path])
+	}))
+	t.Cleanup(server.Close)
+
+	for path := range containers {
+		if _, err := FromURL(context.Background(), server.URL+path, path[1:]); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("%s: expected error '%v', got '%v'", path, ErrUnsupportedType, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// txt, md and source files
+type TextExtractor struct{}
+
+func (TextExtractor) Extract(ctx context.Context, src Source) (string, error) {
+	if !utf8.Valid(src.Data) {
+		return strings.ToValidUTF8(string(src.Data), ""), nil
+	}
+	return string(src.Data), nil
+}
+
+// Rows in the same "(header: value,	header: value)" format parser_api uses for spreadsheets
+type CSVExtractor struct{}
+
+func (CSVExtractor) Extract(ctx context.Context, src Source) (string, error) {
+	reader := csv.NewReader(bytes.NewReader(src.Data))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse csv: %v", err)
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+
+	headers := rows[0]
+	var lines []string
+	for _, row := range rows[1:] {
+		var cells []string
+		for i, cell := range row {
+			header := ""
+			if i < len(headers) {
+				header = headers[i]
+			}
+			cells = append(cells, fmt.Sprintf("%s: %s", header, cell))
+		}
+		lines = append(lines, "("+strings.Join(cells, ",\t")+")")
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Indented so the chunker splits on words instead of one giant line
+type JSONExtractor struct{}
+
+func (JSONExtractor) Extract(ctx context.Context, src Source) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bytes.TrimSpace(src.Data), "", "  "); err != nil {
+		return "", fmt.Errorf("failed to parse json: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// Visible text of the page, without scripts, styles and navigation
+type HTMLExtractor struct{}
+
+var (
+	skippedHTMLTags = map[string]bool{
+		"script": true, "style": true, "noscript": true, "nav": true, "footer": true,
+		"header": true, "aside": true, "form": true, "svg": true, "template": true,
+	}
+	blockHTMLTags = map[string]bool{
+		"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true, "article": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true,
+	}
+	articleHTMLTags = map[string]bool{"article": true, "main": true}
+)
+
+func (HTMLExtractor) Extract(ctx context.Context, src Source) (string, error) {
+	text, _ := htmlText(src.Data, false)
+	return text, nil
+}
+
+// Text of the page's <article> or <main> element when it has one, otherwise
+// all visible text. Also returns the page's <title>.
+func ReadableHTML(data []byte) (text string, title string) {
+	text, title = htmlText(data, true)
+	if text == "" {
+		text, _ = htmlText(data, false)
+	}
+	return text, title
+}
+
+func htmlText(data []byte, articleOnly bool) (string, string) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	var text, title strings.Builder
+	skipDepth, articleDepth := 0, 0
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return cleanWhitespace(text.String()), strings.TrimSpace(title.String())
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if skippedHTMLTags[tag] {
+				skipDepth++
+			}
+			if articleHTMLTags[tag] {
+				articleDepth++
+			}
+			inTitle = tag == "title"
+			if blockHTMLTags[tag] {
+				text.WriteString("\n")
+			}
+			// Keep headings recognizable for the chunker
+			if skipDepth == 0 && len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+				text.WriteString(strings.Repeat("#", int(tag[1]-'0')) + " ")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if skippedHTMLTags[tag] && skipDepth > 0 {
+				skipDepth--
+			}
+			if articleHTMLTags[tag] && articleDepth > 0 {
+				articleDepth--
+			}
+			inTitle = false
+			if blockHTMLTags[tag] {
+				text.WriteString("\n")
+			}
+		case html.TextToken:
+			if inTitle && title.Len() == 0 {
+				title.Write(tokenizer.Text())
+			}
+			if skipDepth == 0 && (!articleOnly || articleDepth > 0) {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+func cleanWhitespace(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,128 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+)
+
+// Highest parser_api response version this client understands
+const PARSER_PROTOCOL_VERSION = 2
+
+// Client for parser_api, used for pdf, docx, xlsx and epub
+type ParserClient struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// Asks parser_api to download the file itself. The bot uploads the file it
+// already downloaded instead, as a multipart form with the same fields and
+// the file under "file".
+type ExtractTextRequest struct {
+	FileURL  string `json:"file_url"`
+	MimeType string `json:"mime_type,omitempty"` // sniffed type, parser_api otherwise goes by the URL's extension
+	Version  int    `json:"version"`
+}
+
+// Version 1 only has ExtractedText and FileSize, older parser_api
+// deployments ignore the requested version and always answer with it
+type ExtractTextResponse struct {
+	Version       int       `json:"version"`
+	ExtractedText string    `json:"extracted_text"`
+	FileSize      int       `json:"file_size"`
+	PageCount     int       `json:"page_count"`
+	Segments      []Segment `json:"segments"`
+}
+
+type ExtractErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewParserClient(baseURL string) *ParserClient {
+	return &ParserClient{BaseURL: baseURL, HTTPClient: &http.Client{}}
+}
+
+func (c *ParserClient) Extract(ctx context.Context, src Source) (string, error) {
+	res, err := c.ExtractText(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	return res.ExtractedText, nil
+}
+
+func (c *ParserClient) ExtractSegments(ctx context.Context, src Source) ([]Segment, int, error) {
+	res, err := c.ExtractText(ctx, src)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.Version < 2 || len(res.Segments) == 0 {
+		return []Segment{{Text: res.ExtractedText}}, 0, nil
+	}
+	return res.Segments, res.PageCount, nil
+}
+
+// Sends the downloaded file and its sniffed type, or only the URL when src has no data
+func (c *ParserClient) ExtractText(ctx context.Context, src Source) (ExtractTextResponse, error) {
+	var payload bytes.Buffer
+	contentType := "application/json"
+	if len(src.Data) > 0 {
+		form := multipart.NewWriter(&payload)
+		form.WriteField("mime_type", src.MimeType)
+		form.WriteField("version", strconv.Itoa(PARSER_PROTOCOL_VERSION))
+		part, err := form.CreateFormFile("file", src.Filename)
+		if err != nil {
+			return ExtractTextResponse{}, fmt.Errorf("failed to create form: %v", err)
+		}
+		part.Write(src.Data)
+		if err := form.Close(); err != nil {
+			return ExtractTextResponse{}, fmt.Errorf("failed to create form: %v", err)
+		}
+		contentType = form.FormDataContentType()
+	} else {
+		err := json.NewEncoder(&payload).Encode(ExtractTextRequest{FileURL: src.URL, MimeType: src.MimeType, Version: PARSER_PROTOCOL_VERSION})
+		if err != nil {
+			return ExtractTextResponse{}, fmt.Errorf("failed to create JSON payload: %v", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/extract_text", &payload)
+	if err != nil {
+		return ExtractTextResponse{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return ExtractTextResponse{}, fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ExtractTextResponse{}, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var result ExtractErrorResponse
+		if err := json.Unmarshal(body, &result); err != nil {
+			return ExtractTextResponse{}, fmt.Errorf("parser api returned status %d", resp.StatusCode)
+		}
+		switch {
+		case resp.StatusCode == http.StatusRequestEntityTooLarge:
+			return ExtractTextResponse{}, ErrTooLarge
+		case resp.StatusCode == http.StatusBadRequest && result.Error == "Unsupported file type":
+			return ExtractTextResponse{}, ErrUnsupportedType
+		}
+		return ExtractTextResponse{}, fmt.Errorf("parser api: %s", result.Error)
+	}
+
+	var result ExtractTextResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return ExtractTextResponse{}, fmt.Errorf("failed to parse JSON response: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,185 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	MAX_PAGE_SIZE         = 5_000_000 // 5mb of html is already a very long article
+	MAX_REDIRECTS         = 5
+	MAX_LINKS_PER_MESSAGE = 5
+	PAGE_TIMEOUT          = 20 * time.Second
+)
+
+var (
+	ErrBlockedAddress   = errors.New("address is not publicly routable")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrInvalidURL       = errors.New("invalid url")
+)
+
+var (
+	pageContentTypes = []string{"text/html", "application/xhtml+xml", "text/plain"}
+	linkPattern      = regexp.MustCompile(`https?://[^\s<>|]+`)
+	// 100.64.0.0/10 isn't covered by net.IP.IsPrivate
+	carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+)
+
+// A fetched web page
+type Page struct {
+	URL   string // after redirects
+	Title string
+	Text  string
+	Size  int
+}
+
+// Fetches web pages without reaching private networks. Every address a
+// request connects to is checked after DNS resolution, including redirects.
+type WebFetcher struct {
+	// Only for local fixture servers, never set this for user supplied links
+	AllowPrivate bool
+	client       *http.Client
+}
+
+func NewWebFetcher(allowPrivate bool) *WebFetcher {
+	f := &WebFetcher{AllowPrivate: allowPrivate}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: f.checkAddress,
+	}
+	f.client = &http.Client{
+		Timeout: PAGE_TIMEOUT,
+		Transport: &http.Transport{
+			Proxy:                 nil, // a proxy would connect to the private address for us
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= MAX_REDIRECTS {
+				return ErrTooManyRedirects
+			}
+			return checkScheme(req.URL)
+		},
+	}
+	return f
+}
+
+// Fetches a page with the default, public-only fetcher
+func FetchWebPage(ctx context.Context, rawURL string) (Page, error) {
+	return NewWebFetcher(false).Fetch(ctx, rawURL)
+}
+
+func (f *WebFetcher) Fetch(ctx context.Context, rawURL string) (Page, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Page{}, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if err := checkScheme(parsed); err != nil {
+		return Page{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("User-Agent", "Intellicord/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to fetch page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Page{}, fmt.Errorf("failed to fetch page: status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !slices.Contains(pageContentTypes, mediaType) {
+		return Page{}, ErrUnsupportedType
+	}
+	if resp.ContentLength > MAX_PAGE_SIZE {
+		return Page{}, ErrTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MAX_PAGE_SIZE+1))
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to read page: %v", err)
+	}
+	if len(data) > MAX_PAGE_SIZE {
+		return Page{}, ErrTooLarge
+	}
+
+	page := Page{URL: resp.Request.URL.String(), Size: len(data)}
+	if mediaType == "text/plain" {
+		page.Text = cleanWhitespace(strings.ToValidUTF8(string(data), ""))
+	} else {
+		page.Text, page.Title = ReadableHTML(data)
+	}
+	if page.Title == "" {
+		page.Title = resp.Request.URL.Host + resp.Request.URL.Path
+	}
+	return page, nil
+}
+
+func (f *WebFetcher) checkAddress(network string, address string, _ syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%s: %w", host, ErrBlockedAddress)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		carrierGradeNAT.Contains(ip))
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme '%s'", ErrInvalidURL, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials aren't allowed", ErrInvalidURL)
+	}
+	return nil
+}
+
+// http(s) links in a message, without duplicates, at most MAX_LINKS_PER_MESSAGE
+func FindLinks(content string) []string {
+	var links []string
+	for _, link := range linkPattern.FindAllString(content, -1) {
+		// Punctuation right after a link is almost always part of the sentence
+		link = strings.TrimRight(link, ".,;:!?)]}'\"*_~`")
+		if slices.Contains(links, link) {
+			continue
+		}
+		links = append(links, link)
+		if len(links) == MAX_LINKS_PER_MESSAGE {
+			break
+		}
+	}
+	return links
+}
+
+func StripLinks(content string) string {
+	return linkPattern.ReplaceAllString(content, "")
+}
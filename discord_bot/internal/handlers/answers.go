@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+)
+
+// Custom IDs of the buttons under thread answers and the feedback modal,
+// arguments follow after a ":"
+const (
+	REGENERATE_BUTTON     = "answer_regenerate"
+	FEEDBACK_BUTTON       = "answer_feedback" // answer_feedback:up or answer_feedback:down
+	SOURCES_BUTTON        = "answer_sources"
+	FEEDBACK_REASON_MODAL = "answer_feedback_reason" // answer_feedback_reason:<answer ID>
+	MAX_SOURCE_EXCERPT    = 200
+)
+
+func answerButtons() []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{CustomID: REGENERATE_BUTTON, Emoji: &discordgo.ComponentEmoji{Name: "🔁"}, Style: discordgo.SecondaryButton},
+				discordgo.Button{CustomID: FEEDBACK_BUTTON + ":up", Emoji: &discordgo.ComponentEmoji{Name: "👍"}, Style: discordgo.SecondaryButton},
+				discordgo.Button{CustomID: FEEDBACK_BUTTON + ":down", Emoji: &discordgo.ComponentEmoji{Name: "👎"}, Style: discordgo.SecondaryButton},
+				discordgo.Button{CustomID: SOURCES_BUTTON, Label: "Sources", Emoji: &discordgo.ComponentEmoji{Name: "📄"}, Style: discordgo.SecondaryButton},
+			},
+		},
+	}
+}
+
+// Saves what the answer was generated from, for the buttons and feedback tracking.
+// logMessageID is what the question was logged under in message_logs, if it was.
+func recordAnswer(question *discordgo.Message, logMessageID string, channelID string, messageIDs []string, config db.LLMConfig, chunks []ai.RetrievedChunk) {
+	if len(messageIDs) == 0 {
+		return
+	}
+	sources := []db.AnswerSource{}
+	for _, chunk := range chunks {
+		excerpt := strings.Join(strings.Fields(chunk.Content), " ")
+		if runes := []rune(excerpt); len(runes) > MAX_SOURCE_EXCERPT {
+			excerpt = string(runes[:MAX_SOURCE_EXCERPT]) + "..."
+		}
+		sources = append(sources, db.AnswerSource{MessageID: chunk.MessageID, Title: chunk.Title, Location: chunk.Location(), Excerpt: excerpt, Distance: chunk.Distance})
+	}
+	_, err := db.AddAnswer(context.Background(), db.Answer{
+		AnswerMessageID:   messageIDs[len(messageIDs)-1],
+		MessageIDs:        messageIDs,
+		QuestionMessageID: question.ID,
+		QuestionChannelID: question.ChannelID,
+		LogMessageID:      logMessageID,
+		AskerID:           question.Author.ID,
+		ServerID:          question.GuildID,
+		ChannelID:         channelID,
+		Company:           config.Company,
+		Model:             config.Model,
+		RetrievalLimit:    len(chunks),
+		Sources:           sources,
+	})
+	if err != nil {
+		log.Printf("Error recording answer: %v", err)
+	}
+}
+
+// The answer the clicked button is under, nil after telling the user it's gone
+func clickedAnswer(s *discordgo.Session, i *discordgo.InteractionCreate) *db.Answer {
+	answer, err := db.GetAnswer(context.Background(), i.Message.ID)
+	if err != nil {
+		log.Printf("Error getting answer: %v", err)
+		respondEphemeral(s, i, "🚨 Failed to find this answer. Database error.")
+		return nil
+	}
+	if answer == nil {
+		respondEphemeral(s, i, "This answer is too old for that.")
+	}
+	return answer
+}
+
+// Generates the answer again in place. Only the person who asked and the server owner can.
+func regenerateAnswerButton() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		answer := clickedAnswer(s, i)
+		if answer == nil {
+			return
+		}
+		guild, err := s.Guild(i.GuildID)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+		user := i.Member.User
+		if user.ID != answer.AskerID && user.ID != guild.OwnerID {
+			respondEphemeral(s, i, "Only the person who asked can regenerate this answer.")
+			return
+		}
+		if banned := db.BanCheck(discordgo.MessageCreate{Message: &discordgo.Message{Author: user, GuildID: i.GuildID}}); len(banned) > 0 {
+			respondEphemeral(s, i, banned)
+			return
+		}
+		if usage := ownerUsage(guild.OwnerID); usage != nil && usage.MessageLimitReached() {
+			respondEphemeral(s, i, "Monthly message limit reached. Upgrade your plan to keep chatting!")
+			return
+		}
+
+		channel, err := s.Channel(answer.ChannelID)
+		if err != nil {
+			log.Println("Error fetching channel:", err)
+			return
+		}
+		question, err := s.ChannelMessage(answer.QuestionChannelID, answer.QuestionMessageID)
+		if err != nil {
+			respondEphemeral(s, i, "The question was deleted, so there's nothing to regenerate.")
+			return
+		}
+		question.GuildID = i.GuildID // not set on fetched messages
+		// Questions from the context menus are posted by the bot on the asker's behalf
+		question.Author = &discordgo.User{ID: answer.AskerID, Username: question.Author.Username}
+		config, err := db.ResolveLLMConfig(i.GuildID, channel.ParentID)
+		if err != nil {
+			log.Println(err)
+			respondEphemeral(s, i, "Can't find the LLM Model you chose.")
+			return
+		}
+
+		// Generating takes longer than Discord waits for a response
+		err = deferResponse(s, i)
+		if err != nil {
+			log.Printf("Error responding to interaction: %v", err)
+			return
+		}
+
+		// History as it was when the question was first answered
+		history := []*discordgo.Message{question}
+		if question.ChannelID == channel.ID {
+			older, err := s.ChannelMessages(channel.ID, THREAD_LIMIT-1, question.ID, "", "")
+			if err != nil {
+				log.Printf("Error getting thread messages: %v", err)
+				return
+			}
+			history = append(history, older...)
+		}
+
+		s.ChannelTyping(channel.ID)
+		// Logged under the interaction, the question already has its own entry
+		go db.AddMessageLog(i.ID, i.GuildID, channel.ID, user.ID)
+		response, rootAttachments, sources, err := generateThreadAnswer(s, channel, question, history, config)
+		if err != nil {
+			log.Printf("Error regenerating answer: %v", err)
+			respondEphemeral(s, i, "Server error. Try again later.")
+			return
+		}
+		var previous []*discordgo.Message
+		for _, id := range answer.MessageIDs {
+			previous = append(previous, &discordgo.Message{ID: id})
+		}
+		messageIDs := replaceAnswerInChannel(s, channel.ID, previous, response, rootAttachments)
+		recordAnswer(question, i.ID, channel.ID, messageIDs, config, sources)
+	}
+}
+
+// Saves the 👍/👎 and asks for an optional reason
+func feedbackButton() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		answer := clickedAnswer(s, i)
+		if answer == nil {
+			return
+		}
+		rating, title, placeholder := 1, "Thanks! What was good?", "Optional, like \"cited the right page\""
+		if strings.HasSuffix(i.MessageComponentData().CustomID, ":down") {
+			rating, title, placeholder = -1, "Thanks! What was wrong?", "Optional, like \"wrong numbers\" or \"missed the second file\""
+		}
+		if err := db.SetAnswerFeedback(context.Background(), answer.ID, i.Member.User.ID, rating); err != nil {
+			log.Printf("Error saving feedback: %v", err)
+			respondEphemeral(s, i, "🚨 Failed to save your feedback. Database error.")
+			return
+		}
+
+		err := respond(s, i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID: fmt.Sprintf("%s:%d", FEEDBACK_REASON_MODAL, answer.ID),
+				Title:    title,
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{
+						Components: []discordgo.MessageComponent{
+							discordgo.TextInput{
+								CustomID:    "reason",
+								Label:       "Reason",
+								Style:       discordgo.TextInputParagraph,
+								Placeholder: placeholder,
+								Required:    false,
+								MaxLength:   1000,
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			log.Printf("Error opening feedback modal: %v", err)
+		}
+	}
+}
+
+func feedbackReasonModal() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		data := i.ModalSubmitData()
+		_, arg, _ := strings.Cut(data.CustomID, ":")
+		answerID, err := strconv.Atoi(arg)
+		if err != nil {
+			log.Printf("Invalid feedback modal ID: %s", data.CustomID)
+			return
+		}
+		if reason := modalTextValue(data, "reason"); reason != "" {
+			if err := db.SetAnswerFeedbackReason(context.Background(), answerID, i.Member.User.ID, reason); err != nil {
+				log.Printf("Error saving feedback reason: %v", err)
+				respondEphemeral(s, i, "🚨 Failed to save your feedback. Database error.")
+				return
+			}
+		}
+		respondEphemeral(s, i, "🙏 Thanks for the feedback!")
+	}
+}
+
+// The value of the modal's text input with the custom ID
+func modalTextValue(data discordgo.ModalSubmitInteractionData, customID string) string {
+	for _, row := range data.Components {
+		actionsRow, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range actionsRow.Components {
+			if input, ok := component.(*discordgo.TextInput); ok && input.CustomID == customID {
+				return strings.TrimSpace(input.Value)
+			}
+		}
+	}
+	return ""
+}
+
+// Lists the chunks the answer was generated from, only to the user who asked for them
+func sourcesButton() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		answer := clickedAnswer(s, i)
+		if answer == nil {
+			return
+		}
+		if len(answer.Sources) == 0 {
+			respondEphemeral(s, i, "📄 This answer didn't use any of the thread's documents.")
+			return
+		}
+		lines := []string{"📄 **Sources**"}
+		for n, source := range answer.Sources {
+			title := source.Title
+			if source.Location != "" {
+				title = fmt.Sprintf("%s (%s)", title, source.Location)
+			}
+			lines = append(lines, fmt.Sprintf("%d. **%s**\n> %s", n+1, title, source.Excerpt))
+		}
+		content := strings.Join(lines, "\n")
+		if runes := []rune(content); len(runes) > MAX_MESSAGE_LENGTH {
+			content = string(runes[:MAX_MESSAGE_LENGTH-3]) + "..."
+		}
+		respondEphemeral(s, i, content)
+	}
+}
+
+// 👍/👎 per model for /showconfig
+func feedbackOverview(serverID string) string {
+	stats, err := db.GetAnswerFeedbackStats(context.Background(), serverID)
+	if err != nil {
+		log.Printf("Error getting feedback stats: %v", err)
+		return "Unavailable"
+	}
+	if len(stats) == 0 {
+		return "No feedback yet. Users can rate answers with 👍/👎 in threads."
+	}
+	var lines []string
+	for _, stat := range stats {
+		line := fmt.Sprintf("**%s** (%s): 👍 %d · 👎 %d", stat.Model, stat.Company, stat.Positive, stat.Negative)
+		if len(strings.Join(append(lines, line), "\n")) > 1024 {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/charts"
+	"github.com/matthewgaim/intellicord/internal/extract"
+)
+
+var spreadsheetExtensions = []string{".csv", ".xlsx"}
+
+// Sends the LLM response, rendering a chart attachment if the response contains a chart spec.
+// attachments are the documents the thread is about, used when the spec references spreadsheet columns.
+// The last message gets the answer buttons. Returns the IDs of the messages sent.
+func sendAnswerInChannel(s *discordgo.Session, channelID string, response string, attachments []*discordgo.MessageAttachment) []string {
+	messages := answerMessages(response, attachments)
+	if len(messages) == 0 {
+		return nil
+	}
+	messages[len(messages)-1].Components = answerButtons()
+	var messageIDs []string
+	for _, msg := range messages {
+		sent, err := s.ChannelMessageSendComplex(channelID, msg)
+		if err != nil {
+			log.Printf("Error sending answer: %v", err)
+			continue
+		}
+		messageIDs = append(messageIDs, sent.ID)
+	}
+	return messageIDs
+}
+
+// Sends the response as a reply to m
+func replyWithAnswer(s *discordgo.Session, m *discordgo.Message, response string) {
+	for n, msg := range answerMessages(response, nil) {
+		if n == 0 {
+			msg.Reference = m.Reference()
+		}
+		if _, err := s.ChannelMessageSendComplex(m.ChannelID, msg); err != nil {
+			log.Printf("Error sending answer: %v", err)
+		}
+	}
+}
+
+// Edits the messages of a previous answer to the new one. Extra messages are
+// sent after it, leftover ones deleted. Returns the IDs of the answer's messages.
+func replaceAnswerInChannel(s *discordgo.Session, channelID string, previous []*discordgo.Message, response string, attachments []*discordgo.MessageAttachment) []string {
+	messages := answerMessages(response, attachments)
+	var messageIDs []string
+	for i, msg := range messages {
+		// Only the last message keeps the buttons
+		components := []discordgo.MessageComponent{}
+		if i == len(messages)-1 {
+			components = answerButtons()
+		}
+		if i >= len(previous) {
+			msg.Components = components
+			sent, err := s.ChannelMessageSendComplex(channelID, msg)
+			if err != nil {
+				log.Printf("Error sending answer: %v", err)
+				continue
+			}
+			messageIDs = append(messageIDs, sent.ID)
+			continue
+		}
+		edit := discordgo.NewMessageEdit(channelID, previous[i].ID)
+		edit.Content = &msg.Content
+		edit.Files = msg.Files
+		edit.Attachments = &[]*discordgo.MessageAttachment{} // drops the previous chart
+		edit.Components = &components
+		if _, err := s.ChannelMessageEditComplex(edit); err != nil {
+			log.Printf("Error editing answer: %v", err)
+		}
+		messageIDs = append(messageIDs, previous[i].ID)
+	}
+	for _, old := range previous[min(len(messages), len(previous)):] {
+		if err := s.ChannelMessageDelete(channelID, old.ID); err != nil {
+			log.Printf("Error deleting old answer: %v", err)
+		}
+	}
+	return messageIDs
+}
+
+// The messages a response is sent as, the chart goes in its own message after the text
+func answerMessages(response string, attachments []*discordgo.MessageAttachment) []*discordgo.MessageSend {
+	spec, text := charts.ExtractSpec(response)
+	if spec == nil {
+		return textMessages(text)
+	}
+
+	if spec.LabelColumn != "" && spec.ValueColumn != "" {
+		rows := getSpreadsheetRows(attachments)
+		if err := spec.ResolveColumns(rows); err != nil {
+			log.Printf("Error resolving chart columns: %v", err)
+		}
+	}
+
+	image, err := charts.Render(*spec)
+	if err != nil {
+		log.Printf("Error rendering chart: %v", err)
+		text = strings.TrimSpace(fmt.Sprintf("%s\n-# 🚨 Couldn't draw the chart: %s", text, err.Error()))
+		return textMessages(text)
+	}
+
+	return append(textMessages(text), &discordgo.MessageSend{
+		Files: []*discordgo.File{
+			{
+				Name:        "chart.png",
+				ContentType: "image/png",
+				Reader:      bytes.NewReader(image),
+			},
+		},
+	})
+}
+
+func textMessages(text string) []*discordgo.MessageSend {
+	var messages []*discordgo.MessageSend
+	for _, piece := range splitMessage(text) {
+		messages = append(messages, &discordgo.MessageSend{Content: piece})
+	}
+	return messages
+}
+
+func getSpreadsheetRows(attachments []*discordgo.MessageAttachment) []map[string]string {
+	var rows []map[string]string
+	for _, attachment := range attachments {
+		ext := strings.ToLower(filepath.Ext(attachment.Filename))
+		if !slices.Contains(spreadsheetExtensions, ext) {
+			continue
+		}
+		extracted, err := extract.FromURL(context.Background(), attachment.URL, attachment.Filename)
+		if err != nil {
+			log.Printf("Error getting spreadsheet text for chart: %v", err)
+			continue
+		}
+		rows = append(rows, charts.ParseRows(extracted.Text)...)
+	}
+	return rows
+}
@@ -1,20 +1,50 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"regexp"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/matthewgaim/intellicord/internal/ai"
 	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/extract"
+	"github.com/matthewgaim/intellicord/internal/jobs"
+)
+
+const (
+	ASK_QUESTION_MODAL      = "ask_question" // ask_question:<interaction ID> when a file was given
+	MAX_ASK_QUESTION_LENGTH = 4000           // the most a modal text input takes
+	MAX_THREAD_TITLE_LENGTH = 60
 )
 
 var (
-	commandHandlers = make(map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate))
-	commands        = []*discordgo.ApplicationCommand{
+	minRetrievalDepth float64 = 1
+	maxRetrievalDepth float64 = 20
+	minTemperature    float64 = 0
+	maxTemperature    float64 = 2
+	minMaxTokens      float64 = 1
+	maxMaxTokens      float64 = 32768
+	minTopP           float64 = 0.01
+	maxTopP           float64 = 1
+
+	pendingAskFiles = sync.Map{} // interaction ID -> file given to /ask while the modal is open
+
+	codeBlockPattern     = regexp.MustCompile("(?s)```.*?(```|$)")
+	discordMarkupPattern = regexp.MustCompile(`<(@[!&]?|#|a?:\w+:)\d+>`)
+	urlPattern           = regexp.MustCompile(`https?://\S+`)
+
+	commandHandlers      = make(map[string]route)
+	autocompleteHandlers = make(map[string]route)
+	// keyed by the custom ID up to the first ":"
+	componentHandlers = make(map[string]route)
+	modalHandlers     = make(map[string]route)
+	commands          = []*discordgo.ApplicationCommand{
 		{
 			Name:        "ping",
 			Description: "Replies with pong!",
@@ -26,9 +56,122 @@ var (
 				{
 					Type:        discordgo.ApplicationCommandOptionString,
 					Name:        "question",
-					Description: "Your question",
+					Description: "Your question, leave it out to write a longer one",
+					Required:    false,
+					MaxLength:   1000,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionAttachment,
+					Name:        "file",
+					Description: "A file to ask about",
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "addurl",
+			Description: "Index a web page and start a thread about it",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "url",
+					Description: "Link to the page",
 					Required:    true,
-					MaxLength:   100,
+					MaxLength:   2000,
+				},
+			},
+		},
+		{
+			Name:        "knowledge",
+			Description: "Manage the server's knowledge base, used by /ask and mentions",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "add",
+					Description: "Pin an uploaded document into the knowledge base",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "document",
+							Description:  "The document to pin",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "remove",
+					Description: "Take a document out of the knowledge base",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "document",
+							Description:  "The document to remove",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "Show the documents in the knowledge base",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "mode",
+					Description: "What /ask and mentions answer from",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "mode",
+							Description: "Where answers come from",
+							Required:    true,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "Knowledge base only", Value: db.KnowledgeOnly},
+								{Name: "Knowledge base + general knowledge", Value: db.KnowledgeAndGeneral},
+								{Name: "General knowledge only", Value: db.GeneralOnly},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "indexchannel",
+			Description: "Index this channel's messages into the knowledge base",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "start",
+					Description: "Index past and new messages of this channel",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "stop",
+					Description: "Remove this channel's messages from the knowledge base",
+				},
+			},
+		},
+		{
+			Name:        "reindex",
+			Description: "Re-index this server's documents after a model or chunker upgrade",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "start",
+					Description: "Re-chunk and re-embed documents in the background",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "status",
+					Description: "Show the progress of the latest re-index",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "cancel",
+					Description: "Stop the running re-index and keep the current index",
 				},
 			},
 		},
@@ -59,28 +202,11 @@ var (
 							Description: "Choose an OpenAI model",
 							Options: []*discordgo.ApplicationCommandOption{
 								{
-									Type:        discordgo.ApplicationCommandOptionString,
-									Name:        "name",
-									Description: "The model to use",
-									Required:    true,
-									Choices: []*discordgo.ApplicationCommandOptionChoice{
-										{
-											Name:  "GPT-4.1 Nano",
-											Value: "gpt-4.1-nano",
-										},
-										{
-											Name:  "GPT-5.1",
-											Value: "gpt-5.1",
-										},
-										{
-											Name:  "GPT-5",
-											Value: "gpt-5",
-										},
-										{
-											Name:  "GPT-4o Mini",
-											Value: "gpt-4o-mini",
-										},
-									},
+									Type:         discordgo.ApplicationCommandOptionString,
+									Name:         "name",
+									Description:  "The model to use",
+									Required:     true,
+									Autocomplete: true,
 								},
 							},
 						},
@@ -97,28 +223,11 @@ var (
 							Description: "Choose a Google model",
 							Options: []*discordgo.ApplicationCommandOption{
 								{
-									Type:        discordgo.ApplicationCommandOptionString,
-									Name:        "name",
-									Description: "The model to use",
-									Required:    true,
-									Choices: []*discordgo.ApplicationCommandOptionChoice{
-										{
-											Name:  "Gemini 2.5 Flash Lite",
-											Value: "gemini-2.5-flash-lite",
-										},
-										{
-											Name:  "Gemini 2.5 Flash",
-											Value: "gemini-2.5-flash",
-										},
-										{
-											Name:  "Gemini 2.5 Pro",
-											Value: "gemini-2.5-pro",
-										},
-										{
-											Name:  "Gemini 3 Pro Preview",
-											Value: "gemini-3-pro-preview",
-										},
-									},
+									Type:         discordgo.ApplicationCommandOptionString,
+									Name:         "name",
+									Description:  "The model to use",
+									Required:     true,
+									Autocomplete: true,
 								},
 							},
 						},
@@ -135,15 +244,138 @@ var (
 							Description: "Set the custom model name (e.g., llama3.2, gpt-oss-120b, etc.)",
 							Options: []*discordgo.ApplicationCommandOption{
 								{
-									Type:        discordgo.ApplicationCommandOptionString,
-									Name:        "name",
-									Description: "Enter the model name to use",
-									Required:    true,
+									Type:         discordgo.ApplicationCommandOptionString,
+									Name:         "name",
+									Description:  "Enter the model name to use",
+									Required:     true,
+									Autocomplete: true,
 								},
 							},
 						},
 					},
 				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "params",
+					Description: "Set generation parameters (leave an option out to keep it as is)",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionNumber,
+							Name:        "temperature",
+							Description: "Higher is more creative (0-2)",
+							MinValue:    &minTemperature,
+							MaxValue:    maxTemperature,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "max_tokens",
+							Description: "Maximum length of a response in tokens",
+							MinValue:    &minMaxTokens,
+							MaxValue:    maxMaxTokens,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionNumber,
+							Name:        "top_p",
+							Description: "Nucleus sampling (0.01-1)",
+							MinValue:    &minTopP,
+							MaxValue:    maxTopP,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "reasoning_effort",
+							Description: "How long reasoning models think before answering",
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "Minimal", Value: "minimal"},
+								{Name: "Low", Value: "low"},
+								{Name: "Medium", Value: "medium"},
+								{Name: "High", Value: "high"},
+							},
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "reset",
+							Description: "Go back to the provider's defaults",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "channelconfig",
+			Description: "Override Intellicord's settings for this channel",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "model",
+					Description: "Use a different LLM in this channel",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "provider",
+							Description: "The LLM provider",
+							Required:    true,
+							Choices: []*discordgo.ApplicationCommandOptionChoice{
+								{Name: "OpenAI", Value: "openai"},
+								{Name: "Google", Value: "google"},
+								{Name: "Custom", Value: "custom"},
+							},
+						},
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "name",
+							Description:  "The model to use",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "persona",
+					Description: "Extra instructions for how Intellicord behaves in this channel",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "instructions",
+							Description: "i.e. You are a senior Go engineer, answer with code examples",
+							Required:    true,
+							MaxLength:   1000,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "retrieval",
+					Description: "How many document chunks to use as context",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionInteger,
+							Name:        "depth",
+							Description: "Number of chunks (1-20)",
+							Required:    true,
+							MinValue:    &minRetrievalDepth,
+							MaxValue:    maxRetrievalDepth,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "autothread",
+					Description: "Start a thread automatically when files are uploaded",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionBoolean,
+							Name:        "enabled",
+							Description: "Off = files are indexed, reply to the upload to start a thread",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reset",
+					Description: "Remove all overrides, this channel uses the server's settings again",
+				},
 			},
 		},
 		{
@@ -180,18 +412,104 @@ var (
 				},
 			},
 		},
+		{
+			Name:        "docs",
+			Description: "See and manage the documents Intellicord has indexed",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "list",
+					Description: "List the server's documents",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionChannel,
+							Name:        "channel",
+							Description: "Only documents posted in this channel",
+							Required:    false,
+						},
+						{
+							Type:        discordgo.ApplicationCommandOptionUser,
+							Name:        "uploader",
+							Description: "Only documents this user uploaded",
+							Required:    false,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "info",
+					Description: "Show a document's size, type, chunks and where it was posted",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "document",
+							Description:  "The document to show",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "delete",
+					Description: "Delete a document and everything indexed from it",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:         discordgo.ApplicationCommandOptionString,
+							Name:         "document",
+							Description:  "The document to delete",
+							Required:     true,
+							Autocomplete: true,
+						},
+					},
+				},
+			},
+		},
+		// Right-click menus, they have no description or options
+		{Name: ASK_MESSAGE_COMMAND, Type: discordgo.MessageApplicationCommand},
+		{Name: SUMMARIZE_MESSAGE_COMMAND, Type: discordgo.MessageApplicationCommand},
+		{Name: EXPLAIN_MESSAGE_COMMAND, Type: discordgo.MessageApplicationCommand},
+		{Name: ASK_USER_FILES_COMMAND, Type: discordgo.UserApplicationCommand},
 	}
 )
 
 func InitCommands() {
-	commandHandlers["ping"] = pingCommand()
-	commandHandlers["ask"] = askCommand()
-	commandHandlers["addchannel"] = addChannelCommand()
-	commandHandlers["delchannel"] = removeChannelCommand()
-	commandHandlers["config"] = updateLLMConfig()
-	commandHandlers["showconfig"] = showConfigCommand()
-	commandHandlers["banuser"] = banUserCommand()
-	commandHandlers["unbanuser"] = unbanUserCommand()
+	commandHandlers["ping"] = route{public: true, handle: pingCommand()}
+	// Opens a modal without a question, with one it defers right away itself
+	commandHandlers["ask"] = route{public: true, manual: true, handle: askCommand()}
+	commandHandlers["addurl"] = route{public: true, handle: addURLCommand()}
+	commandHandlers["knowledge"] = route{handle: knowledgeCommand()}
+	commandHandlers["docs"] = route{handle: docsCommand()}
+	commandHandlers["indexchannel"] = route{public: true, handle: indexChannelCommand()}
+	commandHandlers["reindex"] = route{handle: reindexCommand()}
+	commandHandlers["addchannel"] = route{handle: addChannelCommand()}
+	commandHandlers["delchannel"] = route{handle: removeChannelCommand()}
+	commandHandlers["config"] = route{handle: updateLLMConfig()}
+	commandHandlers["channelconfig"] = route{handle: channelConfigCommand()}
+	commandHandlers["showconfig"] = route{handle: showConfigCommand()}
+	commandHandlers["banuser"] = route{handle: banUserCommand()}
+	commandHandlers["unbanuser"] = route{handle: unbanUserCommand()}
+	// These open a modal, which can't be deferred
+	commandHandlers[ASK_MESSAGE_COMMAND] = route{manual: true, handle: messageContextCommand("ask")}
+	commandHandlers[SUMMARIZE_MESSAGE_COMMAND] = route{manual: true, handle: messageContextCommand("summarize")}
+	commandHandlers[EXPLAIN_MESSAGE_COMMAND] = route{manual: true, handle: messageContextCommand("explain")}
+	commandHandlers[ASK_USER_FILES_COMMAND] = route{manual: true, handle: userFilesContextCommand()}
+
+	autocompleteHandlers["config"] = route{handle: configModelAutocomplete()}
+	autocompleteHandlers["channelconfig"] = route{handle: channelConfigModelAutocomplete()}
+	autocompleteHandlers["knowledge"] = route{handle: knowledgeDocumentAutocomplete()}
+	autocompleteHandlers["docs"] = route{handle: docsDocumentAutocomplete()}
+
+	componentHandlers[REGENERATE_BUTTON] = route{handle: regenerateAnswerButton()}
+	componentHandlers[FEEDBACK_BUTTON] = route{manual: true, handle: feedbackButton()}
+	componentHandlers[SOURCES_BUTTON] = route{handle: sourcesButton()}
+	componentHandlers[DOCS_PAGE_BUTTON] = route{handle: docsPageButton()}
+	componentHandlers[DOCS_DELETE_BUTTON] = route{handle: docsDeleteButton()}
+	componentHandlers[DOCS_CANCEL_BUTTON] = route{handle: docsCancelButton()}
+
+	modalHandlers[FEEDBACK_REASON_MODAL] = route{handle: feedbackReasonModal()}
+	modalHandlers[CONTEXT_QUESTION_MODAL] = route{handle: contextQuestionModal()}
+	modalHandlers[ASK_QUESTION_MODAL] = route{public: true, handle: askQuestionModal()}
 }
 
 func updateLLMConfig() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -203,6 +521,10 @@ func updateLLMConfig() func(s *discordgo.Session, i *discordgo.InteractionCreate
 		}
 		if i.Member.User.ID == guild.OwnerID {
 			options := i.ApplicationCommandData().Options
+			if options[0].Type == discordgo.ApplicationCommandOptionSubCommand && options[0].Name == "params" {
+				updateGenerationParams(s, i, options[0].Options)
+				return
+			}
 			subcommandGroup := options[0]
 
 			companyName := subcommandGroup.Name
@@ -210,40 +532,144 @@ func updateLLMConfig() func(s *discordgo.Session, i *discordgo.InteractionCreate
 			modelOption := subcommand.Options[0]
 			modelName := modelOption.Value.(string)
 
-			if err = db.UpdateServersLLMConfig(guild.ID, companyName, modelName); err != nil {
+			// Validation runs a test prompt, the router defers the response if it takes long
+			responseMessage := fmt.Sprintf("LLM configuration updated!\nProvider: **%s**\nModel: **%s**", companyName, modelName)
+			if err = ai.ValidateModel(context.Background(), companyName, modelName); err != nil {
+				log.Printf("Model validation failed for %s/%s: %v", companyName, modelName, err)
+				responseMessage = fmt.Sprintf("🚨 Configuration not saved: %s", err.Error())
+			} else if err = db.UpdateServersLLMConfig(guild.ID, companyName, modelName); err != nil {
 				log.Println(err.Error())
-				return
+				responseMessage = "🚨 Failed to save configuration. Database error."
+			}
+			respondEphemeral(s, i, responseMessage)
+		} else {
+			respondEphemeral(s, i, "You are not the owner!")
+		}
+	}
+}
+
+func updateGenerationParams(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	params, err := db.GetServerGenerationParams(i.GuildID)
+	if err != nil {
+		log.Printf("Error getting generation params: %v", err)
+	}
+	for _, opt := range options {
+		switch opt.Name {
+		case "temperature":
+			temperature := opt.FloatValue()
+			params.Temperature = &temperature
+		case "max_tokens":
+			maxTokens := int(opt.IntValue())
+			params.MaxTokens = &maxTokens
+		case "top_p":
+			topP := opt.FloatValue()
+			params.TopP = &topP
+		case "reasoning_effort":
+			effort := opt.StringValue()
+			params.ReasoningEffort = &effort
+		case "reset":
+			if opt.BoolValue() {
+				params = db.GenerationParams{}
 			}
+		}
+	}
 
-			// Construct the response message
-			responseMessage := fmt.Sprintf("LLM configuration updated!\nProvider: **%s**\nModel: **%s**", companyName, modelName)
+	responseMessage := fmt.Sprintf("Generation parameters updated!\n%s", generationParamsSummary(params))
+	if err = db.UpdateServerGenerationParams(i.GuildID, params); err != nil {
+		log.Printf("Error updating generation params: %v", err)
+		responseMessage = fmt.Sprintf("🚨 Parameters not saved: %s", err.Error())
+	}
+	respondEphemeral(s, i, responseMessage)
+}
 
-			// Respond to the user's interaction
-			err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: responseMessage,
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
-			if err != nil {
-				log.Printf("Error responding to interaction: %v", err)
+func generationParamsSummary(params db.GenerationParams) string {
+	temperature, maxTokens, topP, effort := "default", "default", "default", "default"
+	if params.Temperature != nil {
+		temperature = fmt.Sprintf("%g", *params.Temperature)
+	}
+	if params.MaxTokens != nil {
+		maxTokens = fmt.Sprintf("%d", *params.MaxTokens)
+	}
+	if params.TopP != nil {
+		topP = fmt.Sprintf("%g", *params.TopP)
+	}
+	if params.ReasoningEffort != nil {
+		effort = *params.ReasoningEffort
+	}
+	return fmt.Sprintf("**Temperature:** %s\n**Max tokens:** %s\n**Top P:** %s\n**Reasoning effort:** %s", temperature, maxTokens, topP, effort)
+}
+
+// Suggests models for /config <provider> model
+func configModelAutocomplete() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		subcommandGroup := i.ApplicationCommandData().Options[0]
+		company := subcommandGroup.Name
+		typed := focusedOptionValue(subcommandGroup.Options)
+		respondWithModelChoices(s, i, company, typed)
+	}
+}
+
+// Suggests models for /channelconfig model, based on the chosen provider
+func channelConfigModelAutocomplete() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		subcommand := i.ApplicationCommandData().Options[0]
+		company := ""
+		for _, opt := range subcommand.Options {
+			if opt.Name == "provider" {
+				company = opt.StringValue()
 			}
-		} else {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "You are not the owner!",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+		}
+		typed := focusedOptionValue(subcommand.Options)
+		respondWithModelChoices(s, i, company, typed)
+	}
+}
+
+func respondWithModelChoices(s *discordgo.Session, i *discordgo.InteractionCreate, company string, typed string) {
+	// Discord drops autocomplete responses after 3 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	models, err := ai.ListModels(ctx, company)
+	if err != nil {
+		log.Printf("Error listing %s models: %v", company, err)
+	}
+	typed = strings.ToLower(typed)
+	for _, model := range models {
+		if len(choices) == MAX_AUTOCOMPLETE_CHOICES {
+			break
+		}
+		if strings.Contains(strings.ToLower(model), typed) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: model, Value: model})
 		}
 	}
+
+	err = respond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to autocomplete: %v", err)
+	}
+}
+
+func focusedOptionValue(options []*discordgo.ApplicationCommandInteractionDataOption) string {
+	for _, opt := range options {
+		if opt.Focused {
+			return opt.StringValue()
+		}
+		if value := focusedOptionValue(opt.Options); value != "" {
+			return value
+		}
+	}
+	return ""
 }
 
 func pingCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		respond(s, i, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
 				Content: "Pong!",
@@ -254,57 +680,229 @@ func pingCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 
 func askCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		options := i.ApplicationCommandData().Options
-		userMessage := options[0].StringValue()
-
-		// Defer the response to avoid a timeout
-		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
-		})
-		if err != nil {
-			log.Println("Error deferring response:", err.Error())
+		data := i.ApplicationCommandData()
+		var question string
+		var file *discordgo.MessageAttachment
+		for _, opt := range data.Options {
+			switch opt.Name {
+			case "question":
+				question = strings.TrimSpace(opt.StringValue())
+			case "file":
+				file = data.Resolved.Attachments[opt.Value.(string)]
+			}
+		}
+		if question != "" {
+			startAskThread(s, i, question, file)
 			return
 		}
 
-		// Send an initial message to act as the parent of the thread
-		initialMsg, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: userMessage,
+		// Too long for an option, ask in a modal. The file waits for the answer.
+		customID := ASK_QUESTION_MODAL
+		if file != nil {
+			pendingAskFiles.Store(i.ID, file)
+			time.AfterFunc(INTERACTION_TTL, func() { pendingAskFiles.Delete(i.ID) })
+			customID += ":" + i.ID
+		}
+		err := respond(s, i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID: customID,
+				Title:    "Ask Intellicord",
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{
+						Components: []discordgo.MessageComponent{
+							discordgo.TextInput{
+								CustomID:    "question",
+								Label:       "Question",
+								Style:       discordgo.TextInputParagraph,
+								Placeholder: "Ask anything, code and long text are fine",
+								Required:    true,
+								MaxLength:   MAX_ASK_QUESTION_LENGTH,
+							},
+						},
+					},
+				},
+			},
 		})
 		if err != nil {
-			log.Println("Error sending initial message:", err.Error())
+			log.Printf("Error opening question modal: %v", err)
+		}
+	}
+}
+
+func askQuestionModal() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		data := i.ModalSubmitData()
+		var file *discordgo.MessageAttachment
+		if _, key, ok := strings.Cut(data.CustomID, ":"); ok {
+			pending, found := pendingAskFiles.LoadAndDelete(key)
+			if !found {
+				respondEphemeral(s, i, "The file expired, run `/ask` again.")
+				return
+			}
+			file = pending.(*discordgo.MessageAttachment)
+		}
+		startAskThread(s, i, modalTextValue(data, "question"), file)
+	}
+}
+
+// Starts a thread on the question and answers it, from the file if there's one
+func startAskThread(s *discordgo.Session, i *discordgo.InteractionCreate, question string, file *discordgo.MessageAttachment) {
+	var usage *db.OwnerUsage
+	if file != nil {
+		// Indexing the file is an upload, allowed where uploads are
+		var reason string
+		usage, reason = checkInteractionAllowed(s, i, i.ChannelID)
+		if reason != "" {
+			respondEphemeral(s, i, reason)
 			return
 		}
+		// Checked before anything is posted, the job's ID isn't known yet
+		if _, notice := applyUploadQuota(usage, []jobs.Job{{Filename: file.Filename, Size: file.Size}}); notice != "" {
+			respondEphemeral(s, i, notice)
+			return
+		}
+	}
+
+	// Defer the response to avoid a timeout
+	err := deferResponse(s, i)
+	if err != nil {
+		log.Println("Error deferring response:", err.Error())
+		return
+	}
+
+	// Send an initial message to act as the parent of the thread
+	content := question
+	if runes := []rune(content); len(runes) > MAX_MESSAGE_LENGTH {
+		content = string(runes[:MAX_MESSAGE_LENGTH-3]) + "..."
+	}
+	initialMsg, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Content: content,
+	})
+	if err != nil {
+		log.Println("Error sending initial message:", err.Error())
+		return
+	}
+
+	if initialMsg == nil || initialMsg.ID == "" {
+		log.Println("Initial message is nil or missing an ID")
+		return
+	}
+
+	thread, err := s.MessageThreadStart(i.ChannelID, initialMsg.ID, threadTitle(question), 60)
+	if err != nil {
+		log.Println("Error creating thread:", err.Error())
+		return
+	}
+
+	// The whole question, the thread's history is what the model sees of it later
+	sendResponseInChannel(s, thread.ID, fmt.Sprintf("-# Initial Message: %s", question))
+
+	config, err := db.ResolveLLMConfig(i.GuildID, i.ChannelID)
+	if err != nil {
+		log.Println(err)
+		sendResponseInChannel(s, thread.ID, "Can't find the LLM Model you chose.")
+		return
+	}
+
+	if file != nil {
+		// Indexed under the initial message like an upload, then answered from it
+		m := &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:          initialMsg.ID,
+			ChannelID:   i.ChannelID,
+			GuildID:     i.GuildID,
+			Content:     question,
+			Author:      i.Member.User,
+			Attachments: []*discordgo.MessageAttachment{file},
+		}}
+		queued, notice := applyUploadQuota(usage, attachmentJobs(m.Message, i.ChannelID, thread.ID))
+		if notice != "" {
+			sendResponseInChannel(s, thread.ID, notice)
+		}
+		if jobIDs := enqueueJobs(s, queued, thread.ID); len(jobIDs) > 0 {
+			go answerAfterIngestion(s, m, thread.ID, config, jobIDs)
+		}
+		return
+	}
+
+	response, sources, err := answerFromKnowledgeBase(i.GuildID, question, config)
+	if err != nil {
+		log.Printf("Error answering /ask: %v", err)
+		s.ChannelMessageSend(thread.ID, "Server error. Try again later")
+		return
+	}
+	messageIDs := sendAnswerInChannel(s, thread.ID, response, nil)
+	// The initial message was sent by the bot, the answer is recorded as the user's
+	initialMsg.Author = i.Member.User
+	initialMsg.GuildID = i.GuildID
+	recordAnswer(initialMsg, "", thread.ID, messageIDs, config, sources)
+}
+
+// A short thread name from the question: its first line without markdown,
+// mentions, links or code, cut at a word
+func threadTitle(question string) string {
+	text := codeBlockPattern.ReplaceAllString(question, " ")
+	text = discordMarkupPattern.ReplaceAllString(text, "")
+	text = urlPattern.ReplaceAllString(text, "")
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(strings.Trim(line, "*_~`>|#-• \t")), " ")
+		line = strings.NewReplacer("*", "", "_", "", "~", "", "`", "", "|", "").Replace(line)
+		if line == "" {
+			continue
+		}
+		if runes := []rune(line); len(runes) > MAX_THREAD_TITLE_LENGTH {
+			cut := string(runes[:MAX_THREAD_TITLE_LENGTH])
+			if space := strings.LastIndex(cut, " "); space > MAX_THREAD_TITLE_LENGTH/2 {
+				cut = cut[:space]
+			}
+			line = strings.TrimRight(cut, " ,.;:-") + "…"
+		}
+		return line
+	}
+	return "Question"
+}
 
-		if initialMsg == nil || initialMsg.ID == "" {
-			log.Println("Initial message is nil or missing an ID")
+func addURLCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		links := extract.FindLinks(i.ApplicationCommandData().Options[0].StringValue())
+		if len(links) != 1 {
+			respondEphemeral(s, i, "That doesn't look like a link. It should start with http:// or https://")
 			return
 		}
+		link := links[0]
 
-		thread, err := s.MessageThreadStart(i.ChannelID, initialMsg.ID, userMessage, 60)
-		if err != nil {
-			log.Println("Error creating thread:", err.Error())
+		usage, reason := checkInteractionAllowed(s, i, i.ChannelID)
+		if reason != "" {
+			respondEphemeral(s, i, reason)
+			return
+		}
+		// Checked before the thread exists, the page's size isn't known until it's fetched
+		if _, notice := applyUploadQuota(usage, linkJobs("", i.GuildID, i.Member.User.ID, i.ChannelID, "", links)); notice != "" {
+			respondEphemeral(s, i, notice)
 			return
 		}
 
-		firstMessage := fmt.Sprintf("-# Initial Message: %s", userMessage)
-		_, err = s.ChannelMessageSend(thread.ID, firstMessage)
+		err := deferResponse(s, i)
 		if err != nil {
-			fmt.Println("Error sending message in thread:", err)
+			log.Println("Error deferring response:", err.Error())
+			return
 		}
 
-		company, model, err := db.GetServersLLMConfig(i.GuildID)
+		// The thread starts from this message, so the page is stored under its ID
+		initialMsg, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("🔗 %s", link),
+		})
 		if err != nil {
-			log.Println(err)
-			sendResponseInChannel(s, thread.ID, "Can't find the LLM Model you chose.")
+			log.Println("Error sending initial message:", err.Error())
 			return
 		}
 
-		var empty_history []*discordgo.Message
-		response, err := ai.LlmGenerateText(empty_history, userMessage, company, s.State.User.ID, model)
+		thread, err := s.MessageThreadStart(i.ChannelID, initialMsg.ID, linkThreadName(link), 60)
 		if err != nil {
-			s.ChannelMessageSend(thread.ID, "Server error. Try again later")
+			log.Println("Error creating thread:", err.Error())
+			return
 		}
-		sendResponseInChannel(s, thread.ID, response)
+		enqueueJobs(s, linkJobs(initialMsg.ID, i.GuildID, i.Member.User.ID, i.ChannelID, thread.ID, links), thread.ID)
 	}
 }
 
@@ -316,7 +914,7 @@ func addChannelCommand() func(s *discordgo.Session, i *discordgo.InteractionCrea
 			return
 		}
 
-		selected_channel := i.ChannelID
+		selected_channel := commandChannelID(s, i)
 		if i.Member.User.ID == guild.OwnerID {
 			allowed_channels, err := db.GetAllowedChannels(i.GuildID)
 			if err != nil {
@@ -324,32 +922,14 @@ func addChannelCommand() func(s *discordgo.Session, i *discordgo.InteractionCrea
 				return
 			}
 			if slices.Contains(allowed_channels, selected_channel) {
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "Channel already allowed",
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
+				respondEphemeral(s, i, "Channel already allowed")
 			} else {
 				allowed_channels = append(allowed_channels, selected_channel)
 				db.UpdateAllowedChannels(allowed_channels, guild.ID)
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "✅ Channel can now use Intellicord",
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
+				respondEphemeral(s, i, "✅ Channel can now use Intellicord")
 			}
 		} else {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "You're not the owner!",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+			respondEphemeral(s, i, "You're not the owner!")
 		}
 	}
 }
@@ -362,7 +942,7 @@ func removeChannelCommand() func(s *discordgo.Session, i *discordgo.InteractionC
 			return
 		}
 
-		selected_channel := i.ChannelID
+		selected_channel := commandChannelID(s, i)
 		if i.Member.User.ID == guild.OwnerID {
 			allowed_channels, err := db.GetAllowedChannels(i.GuildID)
 			if err != nil {
@@ -374,34 +954,108 @@ func removeChannelCommand() func(s *discordgo.Session, i *discordgo.InteractionC
 					return channel == selected_channel
 				})
 				db.UpdateAllowedChannels(allowed_channels, guild.ID)
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "✅ Channel is no longer using Intellicord",
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
+				respondEphemeral(s, i, "✅ Channel is no longer using Intellicord")
 			} else {
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "Channel wasn't being used by Intellicord",
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
+				respondEphemeral(s, i, "Channel wasn't being used by Intellicord")
 			}
 		} else {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "You're not the owner!",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+			respondEphemeral(s, i, "You're not the owner!")
+		}
+	}
+}
+
+func channelConfigCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		guild, err := s.Guild(i.GuildID)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+
+		if i.Member.User.ID != guild.OwnerID {
+			respondEphemeral(s, i, "You are not the owner!")
+			return
+		}
+
+		// Model overrides run a test prompt, the router defers the response if it takes long
+		subcommand := i.ApplicationCommandData().Options[0]
+		var responseMessage string
+		if subcommand.Name == "reset" {
+			err = db.ResetChannelSettings(i.ChannelID)
+			responseMessage = "✅ This channel now uses the server's settings"
+		} else {
+			settings, getErr := db.GetChannelSettings(i.ChannelID)
+			if getErr != nil {
+				log.Printf("Error getting channel settings: %v", getErr)
+				respondEphemeral(s, i, "🚨 Failed to get channel settings. Database error.")
+				return
+			}
+
+			options := subcommand.Options
+			switch subcommand.Name {
+			case "model":
+				company := options[0].StringValue()
+				model := options[1].StringValue()
+				if err = ai.ValidateModel(context.Background(), company, model); err != nil {
+					log.Printf("Model validation failed for %s/%s: %v", company, model, err)
+					respondEphemeral(s, i, fmt.Sprintf("🚨 Configuration not saved: %s", err.Error()))
+					return
+				}
+				settings.LLMCompany = &company
+				settings.LLMModel = &model
+				responseMessage = fmt.Sprintf("✅ This channel now uses **%s** (%s)", model, company)
+			case "persona":
+				persona := options[0].StringValue()
+				settings.Persona = &persona
+				responseMessage = "✅ Persona updated for this channel"
+			case "retrieval":
+				depth := int(options[0].IntValue())
+				settings.RetrievalDepth = &depth
+				responseMessage = fmt.Sprintf("✅ This channel now uses %d chunks of context", depth)
+			case "autothread":
+				enabled := options[0].BoolValue()
+				settings.AutoThread = &enabled
+				if enabled {
+					responseMessage = "✅ Uploads in this channel start a thread automatically"
+				} else {
+					responseMessage = "✅ Uploads in this channel are indexed, reply to one to start a thread"
+				}
+			}
+			err = db.UpdateChannelSettings(i.ChannelID, i.GuildID, settings)
 		}
+
+		if err != nil {
+			log.Printf("Error updating channel settings: %v", err)
+			responseMessage = "🚨 Failed to update channel settings. Database error."
+		}
+		respondEphemeral(s, i, responseMessage)
 	}
 }
 
+func channelOverridesSummary(settings db.ChannelSettings) string {
+	var lines []string
+	if settings.LLMCompany != nil && settings.LLMModel != nil {
+		lines = append(lines, fmt.Sprintf("**Model:** %s (%s)", *settings.LLMModel, *settings.LLMCompany))
+	}
+	if settings.Persona != nil {
+		persona := []rune(*settings.Persona)
+		if len(persona) > 200 {
+			persona = append(persona[:200], []rune("...")...)
+		}
+		lines = append(lines, fmt.Sprintf("**Persona:** %s", string(persona)))
+	}
+	if settings.RetrievalDepth != nil {
+		lines = append(lines, fmt.Sprintf("**Retrieval depth:** %d", *settings.RetrievalDepth))
+	}
+	if settings.AutoThread != nil {
+		lines = append(lines, fmt.Sprintf("**Auto-thread:** %t", *settings.AutoThread))
+	}
+	if len(lines) == 0 {
+		return "None. Use `/channelconfig` to override settings for this channel."
+	}
+	return strings.Join(lines, "\n")
+}
+
 func showConfigCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		guild, err := s.Guild(i.GuildID)
@@ -410,12 +1064,15 @@ func showConfigCommand() func(s *discordgo.Session, i *discordgo.InteractionCrea
 			return
 		}
 
-		// 1. Fetch LLM Config
-		company, model, err := db.GetServersLLMConfig(i.GuildID)
+		// 1. Fetch LLM Config (with this channel's overrides)
+		config, err := db.ResolveLLMConfig(i.GuildID, i.ChannelID)
 		if err != nil {
 			log.Println("Error fetching LLM config:", err)
-			company = "Error"
-			model = "Error"
+			config = db.LLMConfig{Company: "Error", Model: "Error"}
+		}
+		channelSettings, err := db.GetChannelSettings(i.ChannelID)
+		if err != nil {
+			log.Println("Error fetching channel settings:", err)
 		}
 
 		// 2. Fetch Allowed Channels
@@ -447,7 +1104,27 @@ func showConfigCommand() func(s *discordgo.Session, i *discordgo.InteractionCrea
 			Fields: []*discordgo.MessageEmbedField{
 				{
 					Name:   "🤖 LLM Settings",
-					Value:  fmt.Sprintf("**Provider:** %s\n**Model:** %s", company, model),
+					Value:  fmt.Sprintf("**Provider:** %s\n**Model:** %s", config.Company, config.Model),
+					Inline: false,
+				},
+				{
+					Name:   "🎛️ Generation Parameters",
+					Value:  generationParamsSummary(config.Params),
+					Inline: false,
+				},
+				{
+					Name:   "📚 Knowledge Base",
+					Value:  knowledgeBaseOverview(i.GuildID),
+					Inline: false,
+				},
+				{
+					Name:   "👍 Answer Feedback",
+					Value:  feedbackOverview(i.GuildID),
+					Inline: false,
+				},
+				{
+					Name:   "🧩 This Channel's Overrides",
+					Value:  channelOverridesSummary(channelSettings),
 					Inline: false,
 				},
 				{
@@ -463,7 +1140,7 @@ func showConfigCommand() func(s *discordgo.Session, i *discordgo.InteractionCrea
 		}
 
 		// 5. Respond with the Embed
-		err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		err = respond(s, i, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
 				Embeds: []*discordgo.MessageEmbed{embed},
@@ -486,13 +1163,7 @@ func banUserCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate)
 
 		// Owner check
 		if i.Member.User.ID != guild.OwnerID {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "You are not the owner!",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+			respondEphemeral(s, i, "You are not the owner!")
 			return
 		}
 
@@ -504,42 +1175,21 @@ func banUserCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate)
 		reason := reasonOption.StringValue()
 
 		if userID == guild.OwnerID {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "You can't ban the owner.",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+			respondEphemeral(s, i, "You can't ban the owner.")
 			return
 		}
 
 		err = db.AddNewBannedUser(userID, i.GuildID, reason)
 		if err != nil {
 			log.Printf("Error banning user %s: %v", userID, err)
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "Failed to ban user. Database error.",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+			respondEphemeral(s, i, "Failed to ban user. Database error.")
 			return
 		}
 
 		// Construct and send success response
 		responseMessage := fmt.Sprintf("✅ User <@%s> has been banned from using Intellicord on this server for: **%s**", userID, reason)
 
-		err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: responseMessage,
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
-		if err != nil {
-			log.Printf("Error responding to interaction: %v", err)
-		}
+		respondEphemeral(s, i, responseMessage)
 	}
 }
 
@@ -553,13 +1203,7 @@ func unbanUserCommand() func(s *discordgo.Session, i *discordgo.InteractionCreat
 
 		// Owner check (Restricting to the server owner)
 		if i.Member.User.ID != guild.OwnerID {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "You are not the owner!",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+			respondEphemeral(s, i, "You are not the owner!")
 			return
 		}
 
@@ -574,28 +1218,13 @@ func unbanUserCommand() func(s *discordgo.Session, i *discordgo.InteractionCreat
 		err = db.UnbanUser(userID, i.GuildID)
 		if err != nil {
 			log.Printf("Error unbanning user %s: %v", userID, err)
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "🚨 Failed to unban user. Database error.",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
+			respondEphemeral(s, i, "🚨 Failed to unban user. Database error.")
 			return
 		}
 
 		// Construct and send success response
 		responseMessage := fmt.Sprintf("✅ User <@%s> has been unbanned and can now use Intellicord on this server.", userID)
 
-		err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: responseMessage,
-				Flags:   discordgo.MessageFlagsEphemeral,
-			},
-		})
-		if err != nil {
-			log.Printf("Error responding to interaction: %v", err)
-		}
+		respondEphemeral(s, i, responseMessage)
 	}
 }
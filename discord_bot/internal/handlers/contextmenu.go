@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/extract"
+	"github.com/matthewgaim/intellicord/internal/jobs"
+)
+
+// Context menu commands, Discord shows their names as is
+const (
+	ASK_MESSAGE_COMMAND       = "Ask Intellicord"
+	SUMMARIZE_MESSAGE_COMMAND = "Summarize"
+	EXPLAIN_MESSAGE_COMMAND   = "Explain"
+	ASK_USER_FILES_COMMAND    = "Ask About Their Files"
+
+	CONTEXT_QUESTION_MODAL = "context_question" // context_question:<action>:<message ID>
+	USER_FILES_SEARCH      = 100                // recent messages searched for the user's files
+)
+
+// What the modal asks and what's asked of the LLM when the user leaves it empty
+type contextAction struct {
+	title       string
+	label       string
+	placeholder string
+	required    bool
+	prompt      string
+}
+
+var contextActions = map[string]contextAction{
+	"ask": {
+		title:       "Ask Intellicord",
+		label:       "Question",
+		placeholder: "What do you want to know about this?",
+		required:    true,
+	},
+	"summarize": {
+		title:       "Summarize",
+		label:       "Anything to focus on?",
+		placeholder: "Optional, like \"the action items\"",
+		prompt:      "Summarize this.",
+	},
+	"explain": {
+		title:       "Explain",
+		label:       "Anything in particular?",
+		placeholder: "Optional, like \"the second paragraph\"",
+		prompt:      "Explain this in simple terms.",
+	},
+}
+
+// Opens the question modal for the right-clicked message
+func messageContextCommand(action string) func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		data := i.ApplicationCommandData()
+		target := data.Resolved.Messages[data.TargetID]
+		if target == nil {
+			respondEphemeral(s, i, "Can't find that message.")
+			return
+		}
+		if action == "ask" && len(target.Attachments) == 0 && len(extract.FindLinks(target.Content)) == 0 {
+			respondEphemeral(s, i, "That message has no files or links. Use `/ask` for general questions.")
+			return
+		}
+		if action != "ask" && strings.TrimSpace(target.Content) == "" && len(target.Attachments) == 0 {
+			respondEphemeral(s, i, "That message has nothing to read.")
+			return
+		}
+		openContextQuestion(s, i, action, target.ID)
+	}
+}
+
+// Opens the question modal for the user's latest message with files in the channel
+func userFilesContextCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		userID := i.ApplicationCommandData().TargetID
+		recent, err := s.ChannelMessages(i.ChannelID, USER_FILES_SEARCH, "", "", "")
+		if err != nil {
+			log.Printf("Error getting channel messages: %v", err)
+			respondEphemeral(s, i, "Can't read this channel's messages.")
+			return
+		}
+		for _, msg := range recent {
+			if msg.Author != nil && msg.Author.ID == userID && (len(msg.Attachments) > 0 || len(extract.FindLinks(msg.Content)) > 0) {
+				openContextQuestion(s, i, "ask", msg.ID)
+				return
+			}
+		}
+		respondEphemeral(s, i, fmt.Sprintf("<@%s> hasn't posted any files or links here recently.", userID))
+	}
+}
+
+func openContextQuestion(s *discordgo.Session, i *discordgo.InteractionCreate, action string, messageID string) {
+	if _, reason := checkInteractionAllowed(s, i, i.ChannelID); reason != "" {
+		respondEphemeral(s, i, reason)
+		return
+	}
+	channel, err := s.Channel(i.ChannelID)
+	if err != nil {
+		log.Println("Error fetching channel:", err)
+		return
+	}
+	if channel.IsThread() {
+		respondEphemeral(s, i, "This only works on messages in a channel, not in a thread.")
+		return
+	}
+
+	a := contextActions[action]
+	err = respond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: fmt.Sprintf("%s:%s:%s", CONTEXT_QUESTION_MODAL, action, messageID),
+			Title:    a.title,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "question",
+							Label:       a.label,
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: a.placeholder,
+							Required:    a.required,
+							MaxLength:   1000,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error opening question modal: %v", err)
+	}
+}
+
+// Starts a bot thread on the message, indexes its files if they aren't yet and answers the question
+func contextQuestionModal() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		data := i.ModalSubmitData()
+		parts := strings.Split(data.CustomID, ":")
+		if len(parts) != 3 {
+			log.Printf("Invalid question modal ID: %s", data.CustomID)
+			return
+		}
+		a, messageID := contextActions[parts[1]], parts[2]
+		question := modalTextValue(data, "question")
+		if question == "" {
+			question = a.prompt
+		} else if a.prompt != "" {
+			question = fmt.Sprintf("%s Focus on: %s", a.prompt, question)
+		}
+
+		// Checked again, things could have changed while the modal was open
+		usage, reason := checkInteractionAllowed(s, i, i.ChannelID)
+		if reason != "" {
+			respondEphemeral(s, i, reason)
+			return
+		}
+		target, err := s.ChannelMessage(i.ChannelID, messageID)
+		if err != nil {
+			respondEphemeral(s, i, "That message was deleted.")
+			return
+		}
+		target.GuildID = i.GuildID // not set on fetched messages
+		if target.Thread != nil {
+			respondEphemeral(s, i, fmt.Sprintf("That message already has a thread, ask in <#%s>.", target.Thread.ID))
+			return
+		}
+		config, err := db.ResolveLLMConfig(i.GuildID, i.ChannelID)
+		if err != nil {
+			log.Println(err)
+			respondEphemeral(s, i, "Can't find the LLM Model you chose.")
+			return
+		}
+
+		links := extract.FindLinks(target.Content)
+		threadName := a.title
+		if len(target.Attachments) > 0 {
+			threadName = target.Attachments[0].Filename
+		} else if len(links) > 0 {
+			threadName = linkThreadName(links[0])
+		}
+		thread, err := s.MessageThreadStartComplex(i.ChannelID, target.ID, &discordgo.ThreadStart{Name: threadName})
+		if err != nil {
+			log.Printf("Error creating thread: %v", err)
+			respondEphemeral(s, i, "Couldn't start a thread on that message.")
+			return
+		}
+		respondEphemeral(s, i, fmt.Sprintf("🧵 Answering in <#%s>", thread.ID))
+
+		asker := i.Member.User
+		questionMsg, err := s.ChannelMessageSend(thread.ID, fmt.Sprintf("**%s:** %s", asker.Username, question))
+		if err != nil {
+			log.Printf("Error sending question: %v", err)
+			return
+		}
+		// Answers are recorded as the user's, regenerating reads the question from here
+		questionMsg.Author = asker
+		questionMsg.GuildID = i.GuildID
+
+		// Files that weren't indexed yet are indexed like an upload in this channel
+		var jobIDs []string
+		indexed, err := db.GetIndexedMessages(context.Background(), []string{target.ID})
+		if err != nil {
+			log.Printf("Error checking for indexed messages: %v", err)
+		}
+		if len(indexed) == 0 && (len(target.Attachments) > 0 || len(links) > 0) {
+			queued := append(attachmentJobs(target, i.ChannelID, thread.ID), linkJobs(target.ID, i.GuildID, target.Author.ID, i.ChannelID, thread.ID, links)...)
+			queued, notice := applyUploadQuota(usage, queued)
+			if notice != "" {
+				sendResponseInChannel(s, thread.ID, notice)
+			}
+			jobIDs = enqueueJobs(s, queued, thread.ID)
+		}
+		go answerAboutMessage(s, i.ID, target, thread.ID, questionMsg, question, config, jobIDs)
+	}
+}
+
+// Answers the question from the message's text and documents, once they're indexed
+func answerAboutMessage(s *discordgo.Session, interactionID string, target *discordgo.Message, threadID string, questionMsg *discordgo.Message, question string, config db.LLMConfig, jobIDs []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), INGESTION_WAIT_TIMEOUT)
+	defer cancel()
+	if _, err := jobs.Wait(ctx, jobIDs); err != nil {
+		log.Printf("Error waiting for files of message %s: %v", target.ID, err)
+	}
+
+	s.ChannelTyping(threadID)
+	// Logged under the interaction, the message was someone else's
+	go db.AddMessageLog(interactionID, target.GuildID, target.ChannelID, questionMsg.Author.ID)
+
+	sources, err := ai.SearchChunks(context.Background(), question, []string{target.ID}, config.RetrievalLimit(max(len(jobIDs), len(target.Attachments))))
+	if err != nil {
+		log.Println("Error searching chunks:", err)
+	}
+	new_user_msg := fmt.Sprintf("Context:\n%s\n\nMessage from %s:\n%s\n\n%s: %s", ai.FormatChunks(sources), target.Author.Username, target.Content, questionMsg.Author.Username, question)
+	response, err := ai.LlmGenerateText(nil, new_user_msg, s.State.User.ID, config)
+	if err != nil {
+		log.Printf("Error answering about message %s: %v", target.ID, err)
+		sendResponseInChannel(s, threadID, "Server error. Try again later.")
+		return
+	}
+	messageIDs := sendAnswerInChannel(s, threadID, response, target.Attachments)
+	recordAnswer(questionMsg, interactionID, threadID, messageIDs, config, sources)
+}
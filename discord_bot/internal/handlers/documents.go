@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/db"
+)
+
+// Custom IDs of the /docs buttons, arguments follow after a ":"
+const (
+	DOCS_PAGE_BUTTON   = "docs_page"   // docs_page:<page>:<channel ID>:<uploader ID>, filters can be empty
+	DOCS_DELETE_BUTTON = "docs_delete" // docs_delete:<document ID>
+	DOCS_CANCEL_BUTTON = "docs_cancel"
+	DOCS_PAGE_SIZE     = 10
+	MAX_LISTED_TITLE   = 80
+)
+
+var documentStatusEmojis = map[string]string{
+	db.DocumentProcessing: "⏳ ",
+	db.DocumentFailed:     "🚨 ",
+}
+
+func docsCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		subcommand := i.ApplicationCommandData().Options[0]
+		if subcommand.Name == "list" {
+			var channelID, uploaderID string
+			for _, opt := range subcommand.Options {
+				switch opt.Name {
+				case "channel":
+					channelID = opt.ChannelValue(nil).ID
+				case "uploader":
+					uploaderID = opt.UserValue(nil).ID
+				}
+			}
+			content, components := documentListPage(i.GuildID, channelID, uploaderID, 0)
+			err := respond(s, i, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content:    content,
+					Components: components,
+					Flags:      discordgo.MessageFlagsEphemeral,
+				},
+			})
+			if err != nil {
+				log.Printf("Error responding to interaction: %v", err)
+			}
+			return
+		}
+
+		documentID, err := strconv.Atoi(subcommand.Options[0].StringValue())
+		if err != nil {
+			respondEphemeral(s, i, "Pick a document from the list.")
+			return
+		}
+		doc, chunkCount, err := db.GetServerDocument(context.Background(), i.GuildID, documentID)
+		if err != nil {
+			log.Printf("Error getting document %d: %v", documentID, err)
+			respondEphemeral(s, i, "🚨 Failed to get the document. Database error.")
+			return
+		}
+		if doc == nil {
+			respondEphemeral(s, i, "That document doesn't exist anymore.")
+			return
+		}
+
+		switch subcommand.Name {
+		case "info":
+			err = respond(s, i, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Embeds: []*discordgo.MessageEmbed{documentEmbed(*doc, chunkCount)},
+					Flags:  discordgo.MessageFlagsEphemeral,
+				},
+			})
+			if err != nil {
+				log.Printf("Error responding to interaction: %v", err)
+			}
+		case "delete":
+			if reason := documentDeleteCheck(s, i, *doc); reason != "" {
+				respondEphemeral(s, i, reason)
+				return
+			}
+			err = respond(s, i, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content: fmt.Sprintf("Delete **%s**? Its %d chunks are removed and answers won't use it anymore. This can't be undone.", doc.Title, chunkCount),
+					Components: []discordgo.MessageComponent{
+						discordgo.ActionsRow{
+							Components: []discordgo.MessageComponent{
+								discordgo.Button{CustomID: fmt.Sprintf("%s:%d", DOCS_DELETE_BUTTON, doc.ID), Label: "Delete", Style: discordgo.DangerButton},
+								discordgo.Button{CustomID: DOCS_CANCEL_BUTTON, Label: "Cancel", Style: discordgo.SecondaryButton},
+							},
+						},
+					},
+					Flags: discordgo.MessageFlagsEphemeral,
+				},
+			})
+			if err != nil {
+				log.Printf("Error responding to interaction: %v", err)
+			}
+		}
+	}
+}
+
+// Why the user can't delete the document, empty if they can. The owner can
+// delete any document, everyone else only their own.
+func documentDeleteCheck(s *discordgo.Session, i *discordgo.InteractionCreate, doc db.Document) string {
+	if doc.MimeType == db.HistoryMimeType {
+		return "That's an indexed channel's history, remove it with `/indexchannel stop` in the channel."
+	}
+	guild, err := s.Guild(i.GuildID)
+	if err != nil {
+		log.Println("Error getting guild")
+		return "Server error. Try again later."
+	}
+	if i.Member.User.ID != guild.OwnerID && i.Member.User.ID != doc.UploaderID {
+		return "Only the server owner and the person who uploaded it can delete this document."
+	}
+	return ""
+}
+
+// The list's text for the page, with buttons to the pages next to it
+func documentListPage(serverID string, channelID string, uploaderID string, page int) (string, []discordgo.MessageComponent) {
+	docs, total, err := db.ListServerDocuments(context.Background(), serverID, channelID, uploaderID, page*DOCS_PAGE_SIZE, DOCS_PAGE_SIZE)
+	if err != nil {
+		log.Printf("Error listing documents: %v", err)
+		return "🚨 Failed to list the documents. Database error.", nil
+	}
+	if total == 0 {
+		return "📄 No documents found.", nil
+	}
+	pages := (total + DOCS_PAGE_SIZE - 1) / DOCS_PAGE_SIZE
+
+	lines := []string{fmt.Sprintf("📄 **Documents** (%d total)", total)}
+	for n, doc := range docs {
+		title := doc.Title
+		if runes := []rune(title); len(runes) > MAX_LISTED_TITLE {
+			title = string(runes[:MAX_LISTED_TITLE]) + "..."
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s[%s](%s) · %s · <t:%d:d> · <@%s>",
+			page*DOCS_PAGE_SIZE+n+1, documentStatusEmojis[doc.Status], title, documentMessageLink(doc), db.FormatBytes(int64(doc.FileSize)), doc.CreatedAt.Unix(), doc.UploaderID))
+	}
+	lines = append(lines, fmt.Sprintf("-# Page %d of %d. See a document with `/docs info`.", page+1, pages))
+	content := strings.Join(lines, "\n")
+	if runes := []rune(content); len(runes) > MAX_MESSAGE_LENGTH {
+		content = string(runes[:MAX_MESSAGE_LENGTH-3]) + "..."
+	}
+	if pages == 1 {
+		return content, nil
+	}
+
+	pageID := func(page int) string {
+		return fmt.Sprintf("%s:%d:%s:%s", DOCS_PAGE_BUTTON, page, channelID, uploaderID)
+	}
+	return content, []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{CustomID: pageID(page - 1), Label: "◀ Previous", Style: discordgo.SecondaryButton, Disabled: page == 0},
+				discordgo.Button{CustomID: pageID(page + 1), Label: "Next ▶", Style: discordgo.SecondaryButton, Disabled: page >= pages-1},
+			},
+		},
+	}
+}
+
+func documentMessageLink(doc db.Document) string {
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", doc.ServerID, doc.ChannelID, doc.MessageID)
+}
+
+func documentEmbed(doc db.Document, chunkCount int) *discordgo.MessageEmbed {
+	mimeType := doc.MimeType
+	if mimeType == "" {
+		mimeType = "Unknown"
+	}
+	pages := "—"
+	if doc.PageCount > 0 {
+		pages = strconv.Itoa(doc.PageCount)
+	}
+	where := fmt.Sprintf("<#%s>, [message](%s)", doc.ChannelID, documentMessageLink(doc))
+	if doc.ThreadID != "" {
+		where += fmt.Sprintf("\nThread: <#%s>", doc.ThreadID)
+	}
+	return &discordgo.MessageEmbed{
+		Title: doc.Title,
+		URL:   doc.SourceURL,
+		Color: 16776960,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Type", Value: mimeType, Inline: true},
+			{Name: "Size", Value: db.FormatBytes(int64(doc.FileSize)), Inline: true},
+			{Name: "Pages", Value: pages, Inline: true},
+			{Name: "Chunks", Value: strconv.Itoa(chunkCount), Inline: true},
+			{Name: "Status", Value: documentStatusEmojis[doc.Status] + doc.Status, Inline: true},
+			{Name: "Uploaded", Value: fmt.Sprintf("<t:%d:f> by <@%s>", doc.CreatedAt.Unix(), doc.UploaderID), Inline: true},
+			{Name: "Posted in", Value: where, Inline: false},
+		},
+		Footer:    &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Document ID: %d", doc.ID)},
+		Timestamp: doc.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func docsPageButton() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		parts := strings.Split(i.MessageComponentData().CustomID, ":")
+		if len(parts) != 4 {
+			log.Printf("Invalid page button ID: %s", i.MessageComponentData().CustomID)
+			return
+		}
+		page, err := strconv.Atoi(parts[1])
+		if err != nil || page < 0 {
+			return
+		}
+		content, components := documentListPage(i.GuildID, parts[2], parts[3], page)
+		updateComponentMessage(s, i, content, components)
+	}
+}
+
+func docsDeleteButton() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		_, arg, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+		documentID, err := strconv.Atoi(arg)
+		if err != nil {
+			log.Printf("Invalid delete button ID: %s", i.MessageComponentData().CustomID)
+			return
+		}
+		ctx := context.Background()
+		doc, _, err := db.GetServerDocument(ctx, i.GuildID, documentID)
+		if err != nil {
+			log.Printf("Error getting document %d: %v", documentID, err)
+			updateComponentMessage(s, i, "🚨 Failed to delete the document. Database error.", nil)
+			return
+		}
+		if doc == nil {
+			updateComponentMessage(s, i, "That document was already deleted.", nil)
+			return
+		}
+		if reason := documentDeleteCheck(s, i, *doc); reason != "" {
+			updateComponentMessage(s, i, reason, nil)
+			return
+		}
+		if err := db.DeleteDocument(ctx, *doc); err != nil {
+			log.Printf("Error deleting document %d: %v", documentID, err)
+			updateComponentMessage(s, i, "🚨 Failed to delete the document. Database error.", nil)
+			return
+		}
+		updateComponentMessage(s, i, fmt.Sprintf("🗑️ Deleted **%s**.", doc.Title), nil)
+	}
+}
+
+func docsCancelButton() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		updateComponentMessage(s, i, "Cancelled, the document was kept.", nil)
+	}
+}
+
+// Replaces the message the clicked button is on, nil components removes the buttons
+func updateComponentMessage(s *discordgo.Session, i *discordgo.InteractionCreate, content string, components []discordgo.MessageComponent) {
+	if components == nil {
+		components = []discordgo.MessageComponent{}
+	}
+	err := respond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("Error updating message: %v", err)
+	}
+}
+
+// Suggests the server's indexed documents for /docs info and /docs delete
+func docsDocumentAutocomplete() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		// Discord drops autocomplete responses after 3 seconds
+		ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+		defer cancel()
+
+		typed := focusedOptionValue(i.ApplicationCommandData().Options)
+		docs, err := db.SearchServerDocuments(ctx, i.GuildID, typed, MAX_AUTOCOMPLETE_CHOICES)
+		if err != nil {
+			log.Printf("Error listing documents: %v", err)
+		}
+		err = respond(s, i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: documentChoices(docs, typed),
+			},
+		})
+		if err != nil {
+			log.Printf("Error responding to autocomplete: %v", err)
+		}
+	}
+}
+
+// Autocomplete choices for the documents whose title contains typed, valued by document ID
+func documentChoices(docs []db.Document, typed string) []*discordgo.ApplicationCommandOptionChoice {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, doc := range docs {
+		if len(choices) == MAX_AUTOCOMPLETE_CHOICES {
+			break
+		}
+		if !strings.Contains(strings.ToLower(doc.Title), strings.ToLower(typed)) {
+			continue
+		}
+		name := doc.Title
+		if runes := []rune(name); len(runes) > 100 {
+			name = string(runes[:100])
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: strconv.Itoa(doc.ID)})
+	}
+	return choices
+}
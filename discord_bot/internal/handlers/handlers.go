@@ -11,17 +11,11 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/matthewgaim/intellicord/internal/ai"
 	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/extract"
 	"github.com/matthewgaim/intellicord/internal/guilds"
+	"github.com/matthewgaim/intellicord/internal/jobs"
 )
 
-func CommandLookupHandler() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-			h(s, i)
-		}
-	}
-}
-
 func BotReadyRegisterCommandsHandler(dg *discordgo.Session) func(s *discordgo.Session, r *discordgo.Ready) {
 	return func(s *discordgo.Session, r *discordgo.Ready) {
 		for _, g := range r.Guilds {
@@ -67,8 +61,9 @@ func BotRespondToThreadHandler() func(s *discordgo.Session, m *discordgo.Message
 		}
 
 		if channel.Type == discordgo.ChannelTypeGuildPublicThread || channel.Type == discordgo.ChannelTypeGuildPrivateThread {
-			// ignore message in a non-bot created thread
-			if channel.OwnerID != s.State.User.ID {
+			// ignore message in a non-bot created thread, unless it's a forum post with documents
+			newForumPost := m.ID == channel.ID && isAllowedForumPost(s, channel)
+			if !newForumPost && !botAnswersInThread(s, channel) {
 				return
 			}
 
@@ -85,62 +80,305 @@ func BotRespondToThreadHandler() func(s *discordgo.Session, m *discordgo.Message
 				return
 			}
 
-			_, messageLimitReached, err := db.CheckOwnerLimits(guild.OwnerID)
-			if err != nil {
-				log.Printf("Error getting owners limits: %v", err)
-			}
-			if messageLimitReached {
+			usage := ownerUsage(guild.OwnerID)
+			if usage != nil && usage.MessageLimitReached() {
 				sendResponseInChannel(s, channel.ID, "Maximum message limit reached. Upgrade for more messages")
 				return
 			}
 
+			if newForumPost {
+				indexForumPost(s, m, channel, usage)
+				return
+			}
+
 			s.ChannelTyping(channel.ID)
 
-			// Don't recognize extra files in a thread
+			// Files posted in the thread join its documents, a question in the
+			// same message is answered once they're indexed
 			if len(m.Attachments) > 0 {
-				s.ChannelMessageDelete(channel.ID, m.Message.ID)
-				sendResponseInChannel(s, channel.ID, "Attached document will not be recognized in context")
+				jobIDs := addThreadAttachments(s, m, channel, usage)
+				if strings.Trim(m.Content, " ") == "" {
+					return
+				}
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), INGESTION_WAIT_TIMEOUT)
+					defer cancel()
+					if _, err := jobs.Wait(ctx, jobIDs); err != nil {
+						log.Printf("Error waiting for files of message %s: %v", m.ID, err)
+					}
+					s.ChannelTyping(channel.ID)
+					answerInThread(s, m, channel)
+				}()
 				return
 			}
+			answerInThread(s, m, channel)
+		}
+	}
+}
 
-			history, err := GetThreadMessages(s, channel.ID, s.State.User.ID)
-			if err != nil {
-				log.Printf("Error getting thread messages: %v\n", err.Error())
+// Answers a message in a bot thread from the thread's documents and history
+func answerInThread(s *discordgo.Session, m *discordgo.MessageCreate, channel *discordgo.Channel) {
+	history, err := GetThreadMessages(s, channel.ID, s.State.User.ID)
+	if err != nil {
+		log.Printf("Error getting thread messages: %v\n", err.Error())
+		return
+	}
+	go db.AddMessageLog(m.Message.ID, m.GuildID, m.ChannelID, m.Author.ID)
+	config, err := db.ResolveLLMConfig(m.GuildID, channel.ParentID)
+	if err != nil {
+		log.Println(err)
+		sendResponseInChannel(s, m.ChannelID, "Can't find the LLM Model you chose.")
+		return
+	}
+	response, rootAttachments, sources, err := generateThreadAnswer(s, channel, m.Message, history, config)
+	if err != nil {
+		log.Printf("Error answering in thread: %v", err)
+		sendResponseInChannel(s, m.ChannelID, "Server error. Try again later.")
+		return
+	}
+	messageIDs := sendAnswerInChannel(s, m.ChannelID, response, rootAttachments)
+	recordAnswer(m.Message, m.Message.ID, m.ChannelID, messageIDs, config, sources)
+}
+
+// Indexes the files and links of a new post in an allowed forum as the post's
+// documents, tagged with the post's tags. Posts without any are left alone.
+func indexForumPost(s *discordgo.Session, m *discordgo.MessageCreate, post *discordgo.Channel, usage *db.OwnerUsage) {
+	links := extract.FindLinks(m.Content)
+	if len(m.Attachments) == 0 && len(links) == 0 {
+		return
+	}
+
+	queued := append(attachmentJobs(m.Message, post.ParentID, post.ID), linkJobs(m.ID, m.GuildID, m.Author.ID, post.ParentID, post.ID, links)...)
+	tags := forumPostTags(s, post)
+	for n := range queued {
+		queued[n].Tags = tags
+	}
+	queued, notice := applyUploadQuota(usage, queued)
+	if notice != "" {
+		sendResponseInChannel(s, post.ID, notice)
+	}
+	if len(queued) == 0 {
+		return
+	}
+	// The post's first message is its root, recording it marks the post as one the bot answers in
+	if err := db.AddThreadDocument(post.ID, m.ID, m.GuildID); err != nil {
+		log.Printf("Error adding thread document: %v", err)
+		return
+	}
+	jobIDs := enqueueJobs(s, queued, post.ID)
+
+	if strings.Trim(extract.StripLinks(m.Content), " ") == "" {
+		return
+	}
+	config, err := db.ResolveLLMConfig(m.GuildID, post.ParentID)
+	if err != nil {
+		log.Println(err)
+		sendResponseInChannel(s, post.ID, "Can't find the LLM Model you chose.")
+		return
+	}
+	go answerAfterIngestion(s, m, post.ID, config, jobIDs)
+}
+
+// Keeps the documents of a forum post tagged with the post's current tags
+func ForumPostTagsUpdatedHandler() func(s *discordgo.Session, t *discordgo.ThreadUpdate) {
+	return func(s *discordgo.Session, t *discordgo.ThreadUpdate) {
+		if t.BeforeUpdate != nil && slices.Equal(t.BeforeUpdate.AppliedTags, t.AppliedTags) {
+			return
+		}
+		if !isAllowedForumPost(s, t.Channel) {
+			return
+		}
+		tags := forumPostTags(s, t.Channel)
+		if err := db.UpdateThreadDocumentTags(context.Background(), t.ID, tags); err != nil {
+			log.Printf("Error updating tags of forum post %s: %v", t.ID, err)
+		}
+	}
+}
+
+// Answers a question in a bot thread from the thread's documents. Also returns
+// the root message's attachments, which charts in the answer can refer to, and
+// the chunks the answer was generated from.
+func generateThreadAnswer(s *discordgo.Session, channel *discordgo.Channel, question *discordgo.Message, history []*discordgo.Message, config db.LLMConfig) (string, []*discordgo.MessageAttachment, []ai.RetrievedChunk, error) {
+	rootMsg, err := getRootMessageOfThread(s, channel)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error getting root message: %v", err)
+	}
+	threadDocuments, err := db.GetThreadDocuments(channel.ID)
+	if err != nil {
+		log.Printf("Error getting thread documents: %v", err)
+	}
+	messageIDs := append([]string{rootMsg.ID}, threadDocuments...)
+	numOfAttachments := len(rootMsg.Attachments) + len(threadDocuments)
+
+	sources, err := ai.SearchChunks(context.Background(), question.Content, messageIDs, config.RetrievalLimit(numOfAttachments))
+	if err != nil {
+		log.Println("Error searching chunks:", err)
+	}
+	new_user_msg := fmt.Sprintf("Additional Context:\n%s\n\n User: %s", ai.FormatChunks(sources), question.Content)
+	response, err := ai.LlmGenerateText(history, new_user_msg, s.State.User.ID, config)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return response, rootMsg.Attachments, sources, nil
+}
+
+// Regenerates the answer when the latest question in a bot thread is edited,
+// replacing the previous answer instead of posting a new one
+func BotReanswerEditedQuestionHandler() func(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	return func(s *discordgo.Session, m *discordgo.MessageUpdate) {
+		// Embeds being added also send an update, without an edit timestamp
+		if m.Author == nil || m.Author.Bot || m.EditedTimestamp == nil {
+			return
+		}
+		if m.BeforeUpdate != nil && m.BeforeUpdate.Content == m.Content {
+			return
+		}
+		if strings.Trim(m.Content, " ") == "" {
+			return
+		}
+		channel, err := s.Channel(m.ChannelID)
+		if err != nil {
+			log.Println("Error fetching channel:", err)
+			return
+		}
+		if !botAnswersInThread(s, channel) {
+			return
+		}
+		if banned := db.BanCheck(discordgo.MessageCreate{Message: m.Message}); len(banned) > 0 {
+			return
+		}
+
+		previousAnswer, err := getAnswerToLatestQuestion(s, channel.ID, m.ID)
+		if err != nil {
+			log.Printf("Error getting previous answer: %v", err)
+			return
+		}
+		if len(previousAnswer) == 0 {
+			return
+		}
+
+		guild, err := s.Guild(m.GuildID)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+		usage := ownerUsage(guild.OwnerID)
+		if usage != nil && usage.MessageLimitReached() {
+			return
+		}
+		config, err := db.ResolveLLMConfig(m.GuildID, channel.ParentID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// History as it was when the question was first answered
+		older, err := s.ChannelMessages(channel.ID, THREAD_LIMIT-1, m.ID, "", "")
+		if err != nil {
+			log.Printf("Error getting thread messages: %v", err)
+			return
+		}
+		history := append([]*discordgo.Message{m.Message}, older...)
+
+		s.ChannelTyping(channel.ID)
+		go db.AddMessageLog(m.ID, m.GuildID, m.ChannelID, m.Author.ID)
+		response, rootAttachments, sources, err := generateThreadAnswer(s, channel, m.Message, history, config)
+		if err != nil {
+			log.Printf("Error regenerating answer: %v", err)
+			response = "Server error. Try again later."
+		}
+		messageIDs := replaceAnswerInChannel(s, channel.ID, previousAnswer, response, rootAttachments)
+		if err == nil {
+			recordAnswer(m.Message, m.ID, channel.ID, messageIDs, config, sources)
+		}
+	}
+}
+
+// Answers messages that mention the bot from the server's knowledge base, like /ask
+func BotMentionedHandler() func(s *discordgo.Session, m *discordgo.MessageCreate) {
+	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		if m.Author.Bot || !slices.ContainsFunc(m.Mentions, func(u *discordgo.User) bool { return u.ID == s.State.User.ID }) {
+			return
+		}
+		// Uploads, links and replies to them are answered in their own thread
+		if len(m.Attachments) > 0 || len(extract.FindLinks(m.Content)) > 0 {
+			return
+		}
+		if m.ReferencedMessage != nil && (len(m.ReferencedMessage.Attachments) > 0 || len(extract.FindLinks(m.ReferencedMessage.Content)) > 0) {
+			return
+		}
+
+		channel, err := s.Channel(m.ChannelID)
+		if err != nil {
+			log.Println("Error fetching channel:", err)
+			return
+		}
+		// Threads of other users are allowed if their channel is, bot threads answer on their own
+		channelID := m.ChannelID
+		if channel.IsThread() {
+			if botAnswersInThread(s, channel) {
 				return
 			}
-			if channel.OwnerID == s.State.User.ID {
-				rootMsg, err := getRootMessageOfThread(s, channel)
-				if err != nil {
-					log.Printf("Error getting root message: %v", err)
-				}
-				numOfAttachments := len(rootMsg.Attachments)
-				rootMsgID := rootMsg.ID
-
-				go db.AddMessageLog(m.Message.ID, m.GuildID, m.ChannelID, m.Author.ID)
-				company, model, err := db.GetServersLLMConfig(m.GuildID)
-				if err != nil {
-					log.Println(err)
-					sendResponseInChannel(s, m.ChannelID, "Can't find the LLM Model you chose.")
-					return
-				}
-				res := ai.QueryVectorDB(context.Background(), m.Content, rootMsgID, numOfAttachments)
-				new_user_msg := fmt.Sprintf("Additional Context:\n%s\n\n User: %s", res, m.Content)
-				response, err := ai.LlmGenerateText(history, new_user_msg, company, s.State.User.ID, model)
-				if err != nil {
-					sendResponseInChannel(s, m.ChannelID, "Server error. Try again later.")
-				}
-				sendResponseInChannel(s, m.ChannelID, response)
-				if err != nil {
-					log.Println("Error sending message in thread:", err)
-				}
-			}
+			channelID = channel.ParentID
+		}
+		allowedChannels, err := db.GetAllowedChannels(m.GuildID)
+		if err != nil {
+			log.Printf("Error fetching allowed channels: %v", err)
+			return
+		}
+		if !slices.Contains(allowedChannels, channelID) {
+			return
+		}
+		if banned := db.BanCheck(*m); len(banned) > 0 {
+			return
+		}
+
+		question := strings.NewReplacer(
+			fmt.Sprintf("<@%s>", s.State.User.ID), "",
+			fmt.Sprintf("<@!%s>", s.State.User.ID), "",
+		).Replace(m.Content)
+		question = strings.TrimSpace(question)
+		if question == "" {
+			replyWithAnswer(s, m.Message, "Ask me something after the mention, i.e. `@Intellicord how do I reset my password?`")
+			return
+		}
+
+		guild, err := s.Guild(m.GuildID)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+		usage := ownerUsage(guild.OwnerID)
+		if usage != nil && usage.MessageLimitReached() {
+			replyWithAnswer(s, m.Message, "Maximum message limit reached. Upgrade for more messages")
+			return
 		}
+		config, err := db.ResolveLLMConfig(m.GuildID, channelID)
+		if err != nil {
+			log.Println(err)
+			replyWithAnswer(s, m.Message, "Can't find the LLM Model you chose.")
+			return
+		}
+
+		s.ChannelTyping(m.ChannelID)
+		go db.AddMessageLog(m.ID, m.GuildID, m.ChannelID, m.Author.ID)
+		response, _, err := answerFromKnowledgeBase(m.GuildID, question, config)
+		if err != nil {
+			log.Printf("Error answering mention: %v", err)
+			replyWithAnswer(s, m.Message, "Server error. Try again later.")
+			return
+		}
+		replyWithAnswer(s, m.Message, response)
 	}
 }
 
 func StartThreadFromAttachmentUploadHandler() func(s *discordgo.Session, m *discordgo.MessageCreate) {
 	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		if len(m.Attachments) == 0 || m.Author.Bot {
+		if m.Author.Bot {
+			return
+		}
+		links := extract.FindLinks(m.Content)
+		if len(m.Attachments) == 0 && len(links) == 0 {
 			return
 		}
 
@@ -167,8 +405,8 @@ func StartThreadFromAttachmentUploadHandler() func(s *discordgo.Session, m *disc
 			log.Println("Error fetching channel:", err)
 			return
 		}
+		// Files in bot threads are added to the thread by BotRespondToThreadHandler
 		if channel.Type == discordgo.ChannelTypeGuildPublicThread || channel.Type == discordgo.ChannelTypeGuildPrivateThread {
-			log.Println("Documents wont be recognized in an existing thread")
 			return
 		}
 
@@ -177,79 +415,66 @@ func StartThreadFromAttachmentUploadHandler() func(s *discordgo.Session, m *disc
 			log.Println("Error getting guild")
 			return
 		}
-		_, messageLimitReached, err := db.CheckOwnerLimits(guild.OwnerID)
+		usage := ownerUsage(guild.OwnerID)
+		if usage != nil && usage.MessageLimitReached() {
+			sendResponseInChannel(s, channel.ID, "Maximum message limit reached. Upgrade for more messages")
+			return
+		}
+
+		config, err := db.ResolveLLMConfig(m.GuildID, m.ChannelID)
 		if err != nil {
-			log.Printf("Error getting owners limits: %v", err)
+			log.Println(err)
+			sendResponseInChannel(s, channel.ID, "Can't find the LLM Model you chose.")
+			return
 		}
-		if messageLimitReached {
-			sendResponseInChannel(s, channel.ID, "Maximum message limit reached. Upgrade for more messages")
+
+		// Index the files without a thread, replying to the upload starts one later
+		if !config.AutoThread {
+			queued := append(attachmentJobs(m.Message, m.ChannelID, ""), linkJobs(m.ID, m.GuildID, m.Author.ID, m.ChannelID, "", links)...)
+			queued, notice := applyUploadQuota(usage, queued)
+			if notice != "" {
+				sendResponseInChannel(s, m.ChannelID, notice)
+			}
+			if len(queued) > 0 {
+				indexSilently(s, m, queued)
+			}
+			return
+		}
+
+		// Checked before the thread is started, so a rejected upload doesn't leave an empty one
+		queued := append(attachmentJobs(m.Message, m.ChannelID, ""), linkJobs(m.ID, m.GuildID, m.Author.ID, m.ChannelID, "", links)...)
+		queued, notice := applyUploadQuota(usage, queued)
+		if len(queued) == 0 {
+			sendResponseInChannel(s, m.ChannelID, notice)
 			return
 		}
 
+		threadName := ""
+		if len(m.Attachments) > 0 {
+			threadName = m.Attachments[0].Filename
+		} else {
+			threadName = linkThreadName(links[0])
+		}
 		data := &discordgo.ThreadStart{
-			Name: m.Attachments[0].Filename,
+			Name: threadName,
 		}
 		thread, err := s.MessageThreadStartComplex(m.ChannelID, m.ID, data)
 		if err != nil {
 			log.Printf("Error creating thread: %v", err)
 			return
 		}
-		s.ChannelTyping(thread.ID)
-		for i, attachment := range m.Attachments {
-			attachmentLink := attachment.URL
-			filename := attachment.Filename
-			log.Printf("Attachment %d: %s (%s)", i, filename, attachmentLink)
-			processingMessage, err := s.ChannelMessageSend(thread.ID, fmt.Sprintf("-# 🔎 Reading file: %s", filename))
-			fileText, fileSize, err := getFileTextAndSize(attachmentLink)
-			if err != nil {
-				err_str := err.Error()
-				if err_str == "Unsupported file type" {
-					s.ChannelMessageEdit(thread.ID, processingMessage.ID, fmt.Sprintf("-# 🚨 File '%s' is an unsupported file type. It wont be analyzed.", filename))
-					continue
-				} else if err_str == "File too large" {
-					s.ChannelMessageEdit(thread.ID, processingMessage.ID, fmt.Sprintf("-# 🚨 File '%s' is too big.", filename))
-					continue
-				} else {
-					log.Printf("Error getting file text: %v", err)
-					continue
-				}
-			}
-			discord_server_id := m.GuildID
-			err = ai.ChunkAndEmbed(context.Background(), m.Message.ID, fileText, filename, attachmentLink, discord_server_id, fileSize, thread.ID, m.Author.ID)
-			if err != nil {
-				s.ChannelMessageEdit(thread.ID, processingMessage.ID, fmt.Sprintf("-# 🚨 There was an error processing file '%s'", filename))
-				continue
-			}
-			_, err = s.ChannelMessageEdit(thread.ID, processingMessage.ID, fmt.Sprintf("-# ✅ File '%s' is ready!", filename))
-			if err != nil {
-				log.Printf("Error sending message in thread: %v", err)
-			}
-		}
-
-		// If user sent a message with the files
-		if strings.Trim(m.Content, " ") != "" {
-			s.ChannelTyping(thread.ID)
-			go db.AddMessageLog(m.Message.ID, m.GuildID, m.ChannelID, m.Author.ID)
-			company, model, err := db.GetServersLLMConfig(m.GuildID)
-			if err != nil {
-				log.Println(err)
-				sendResponseInChannel(s, m.ChannelID, "Can't find the LLM Model you chose.")
-				return
-			}
 
-			numOfAttachments := len(m.Attachments)
-			res := ai.QueryVectorDB(context.Background(), m.Content, m.ID, numOfAttachments)
+		for n := range queued {
+			queued[n].ThreadID = thread.ID
+		}
+		if notice != "" {
+			sendResponseInChannel(s, thread.ID, notice)
+		}
+		jobIDs := enqueueJobs(s, queued, thread.ID)
 
-			var empty_history []*discordgo.Message
-			new_user_msg := fmt.Sprintf("Context:\n%s\n\n%s: %s", res, m.Author.Username, m.Content)
-			response, err := ai.LlmGenerateText(empty_history, new_user_msg, company, s.State.User.ID, model)
-			if err != nil {
-				sendResponseInChannel(s, thread.ID, "Server error. Try again later.")
-			}
-			_, err = s.ChannelMessageSend(thread.ID, response)
-			if err != nil {
-				log.Println("Error sending message in thread:", err)
-			}
+		// If user sent a message with the files or links, answer once they're indexed
+		if len(jobIDs) > 0 && strings.Trim(extract.StripLinks(m.Content), " ") != "" {
+			go answerAfterIngestion(s, m, thread.ID, config, jobIDs)
 		}
 	}
 }
@@ -257,17 +482,34 @@ func StartThreadFromAttachmentUploadHandler() func(s *discordgo.Session, m *disc
 func AttachmentDeletedHandler() func(s *discordgo.Session, m *discordgo.MessageDelete) {
 	return func(s *discordgo.Session, m *discordgo.MessageDelete) {
 		// cant check for attachments since message is deleted
-		message_id := m.Message.ID
-		ai.DeleteEmbeddings(context.Background(), message_id)
+		if !mayHaveIndexedMessages(s, m.GuildID, m.ChannelID) {
+			return
+		}
+		cleanUpDeletedMessages(s, []string{m.Message.ID})
+		forgetDeletedHistory(m.ChannelID, []string{m.Message.ID})
 	}
 }
 
+func AttachmentsBulkDeletedHandler() func(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	return func(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+		if !mayHaveIndexedMessages(s, m.GuildID, m.ChannelID) {
+			return
+		}
+		cleanUpDeletedMessages(s, m.Messages)
+		forgetDeletedHistory(m.ChannelID, m.Messages)
+	}
+}
+
+// Starts a thread from a reply to a message whose files or links were indexed,
+// answering the reply from that message's documents
 func StartThreadFromReplyHandler() func(s *discordgo.Session, m *discordgo.MessageCreate) {
 	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		if m.Author.Bot || m.Type != discordgo.MessageTypeReply {
+		if m.Author.Bot || m.Type != discordgo.MessageTypeReply || m.ReferencedMessage == nil {
 			return
 		}
-		if m.Type == discordgo.MessageTypeReply && len(m.ReferencedMessage.Attachments) == 0 {
+		referenced := m.ReferencedMessage
+		links := extract.FindLinks(referenced.Content)
+		if len(referenced.Attachments) == 0 && len(links) == 0 {
 			return
 		}
 
@@ -297,8 +539,42 @@ func StartThreadFromReplyHandler() func(s *discordgo.Session, m *discordgo.Messa
 			log.Println("Documents wont be recognized in an existing thread")
 			return
 		}
+
+		// Files or links that failed or weren't indexed have nothing to answer from
+		indexed, err := db.GetIndexedMessages(context.Background(), []string{referenced.ID})
+		if err != nil {
+			log.Printf("Error checking for indexed messages: %v", err)
+			return
+		}
+		if len(indexed) == 0 {
+			return
+		}
+
+		guild, err := s.Guild(discord_server_id)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+		usage := ownerUsage(guild.OwnerID)
+		if usage != nil && usage.MessageLimitReached() {
+			sendResponseInChannel(s, channel.ID, "Maximum message limit reached. Upgrade for more messages")
+			return
+		}
+		config, err := db.ResolveLLMConfig(discord_server_id, channel.ID)
+		if err != nil {
+			log.Println(err)
+			sendResponseInChannel(s, channel.ID, "Can't find the LLM Model you chose.")
+			return
+		}
+
+		threadName := ""
+		if len(referenced.Attachments) > 0 {
+			threadName = referenced.Attachments[0].Filename
+		} else {
+			threadName = linkThreadName(links[0])
+		}
 		data := &discordgo.ThreadStart{
-			Name: m.ReferencedMessage.Attachments[0].Filename,
+			Name: threadName,
 		}
 		thread, err := s.MessageThreadStartComplex(channel.ID, m.ID, data)
 		if err != nil {
@@ -308,23 +584,27 @@ func StartThreadFromReplyHandler() func(s *discordgo.Session, m *discordgo.Messa
 		log.Println("MessageThreadStartComplex")
 		s.ChannelTyping(thread.ID)
 
+		// The thread starts from the reply, the documents are on the message it replied to
+		if err := db.AddThreadDocument(thread.ID, referenced.ID, discord_server_id); err != nil {
+			log.Printf("Error adding thread document: %v", err)
+		}
+
 		history, err := GetThreadMessages(s, thread.ID, s.State.User.ID)
 		if err != nil {
 			log.Printf("Error getting thread messages: %v\n", err.Error())
 			return
 		}
-		company, model, err := db.GetServersLLMConfig(discord_server_id)
+		go db.AddMessageLog(m.ID, discord_server_id, m.ChannelID, m.Author.ID)
+		sources, err := ai.SearchChunks(context.Background(), m.Content, []string{referenced.ID}, config.RetrievalLimit(len(referenced.Attachments)+len(links)))
 		if err != nil {
-			log.Println(err)
-			s.ChannelMessageSend(thread.ID, "Can't find the LLM Model you chose.")
-			return
+			log.Println("Error searching chunks:", err)
 		}
-		res := ai.QueryVectorDB(context.Background(), m.Content, m.ReferencedMessage.ID, len(m.ReferencedMessage.Attachments))
-		response, err := ai.LlmGenerateText(history, fmt.Sprintf("Additional Context:\n%s\n\n User: %s", res, m.Content), company, s.State.User.ID, model)
+		response, err := ai.LlmGenerateText(history, fmt.Sprintf("Additional Context:\n%s\n\n User: %s", ai.FormatChunks(sources), m.Content), s.State.User.ID, config)
 		if err != nil {
 			s.ChannelMessageSend(thread.ID, "Server error. Try again later")
 			return
 		}
-		sendResponseInChannel(s, thread.ID, response)
+		messageIDs := sendAnswerInChannel(s, thread.ID, response, referenced.Attachments)
+		recordAnswer(m.Message, m.ID, thread.ID, messageIDs, config, sources)
 	}
 }
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/history"
+)
+
+const (
+	HISTORY_NOTICE = "📚 This channel's messages are being added to the server's knowledge base, so `/ask` and mentions can answer from past conversations. New messages are added as they come in."
+)
+
+// Opts the channel in or out of having its message history indexed into the knowledge base
+func indexChannelCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		guild, err := s.Guild(i.GuildID)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+		if i.Member.User.ID != guild.OwnerID {
+			respondEphemeral(s, i, "You are not the owner!")
+			return
+		}
+
+		existing, err := db.GetChannelHistory(i.ChannelID)
+		if err != nil {
+			log.Printf("Error getting channel history: %v", err)
+			respondEphemeral(s, i, "🚨 Failed to check this channel. Database error.")
+			return
+		}
+
+		ctx := context.Background()
+		switch i.ApplicationCommandData().Options[0].Name {
+		case "start":
+			if existing != nil {
+				respondEphemeral(s, i, "This channel's history is already indexed. New messages are added as they come in.")
+				return
+			}
+			channel, err := s.Channel(i.ChannelID)
+			if err != nil {
+				log.Printf("Error getting channel: %v", err)
+				return
+			}
+			if channel.IsThread() || channel.Type == discordgo.ChannelTypeGuildForum {
+				respondEphemeral(s, i, "Only text channels can be indexed.")
+				return
+			}
+
+			title := history.Title(channel.Name)
+			channelHistory, err := db.EnableChannelHistory(ctx, db.Document{
+				ServerID:       i.GuildID,
+				ChannelID:      i.ChannelID,
+				UploaderID:     i.Member.User.ID,
+				Title:          title,
+				SourceURL:      fmt.Sprintf("https://discord.com/channels/%s/%s", i.GuildID, i.ChannelID),
+				MimeType:       db.HistoryMimeType,
+				EmbeddingModel: ai.EmbeddingModel(),
+				ChunkerVersion: ai.CHUNKER_VERSION,
+			})
+			if err != nil {
+				log.Printf("Error enabling channel history: %v", err)
+				respondEphemeral(s, i, "🚨 Failed to index this channel. Database error.")
+				return
+			}
+
+			// Public, so everyone in the channel knows their messages become searchable
+			respond(s, i, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content: HISTORY_NOTICE,
+				},
+			})
+			go backfillChannelHistory(s, i, channelHistory, title)
+		case "stop":
+			if existing == nil {
+				respondEphemeral(s, i, "This channel's history isn't indexed.")
+				return
+			}
+			if err := db.DisableChannelHistory(ctx, i.ChannelID); err != nil {
+				log.Printf("Error disabling channel history: %v", err)
+				respondEphemeral(s, i, "🚨 Failed to remove this channel's history. Database error.")
+				return
+			}
+			respond(s, i, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content: "🗑️ This channel's history was removed from the knowledge base and new messages won't be indexed.",
+				},
+			})
+		}
+	}
+}
+
+// Indexes the channel's past messages, reporting progress on the command's response
+func backfillChannelHistory(s *discordgo.Session, i *discordgo.InteractionCreate, channelHistory db.ChannelHistory, title string) {
+	status := func(line string) {
+		content := HISTORY_NOTICE + "\n-# " + line
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("Error updating indexing progress: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	stored, err := history.Backfill(ctx, s, channelHistory, title, func(read int) {
+		status(fmt.Sprintf("⏳ Read %d messages...", read))
+	})
+	if err != nil {
+		log.Printf("Error indexing history of channel %s: %v", channelHistory.ChannelID, err)
+		if err := db.SetDocumentStatus(ctx, channelHistory.DocumentID, db.DocumentFailed); err != nil {
+			log.Printf("Error updating document status: %v", err)
+		}
+		status("🚨 Indexing failed. Run `/indexchannel stop` and try again.")
+		return
+	}
+	status(fmt.Sprintf("✅ Indexed %d conversations.", stored))
+}
+
+// Queues new messages of indexed channels for the next sync
+func ChannelHistoryMessageHandler() func(s *discordgo.Session, m *discordgo.MessageCreate) {
+	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		if m.Author == nil || m.Author.Bot || m.GuildID == "" {
+			return
+		}
+		history.MessagePosted(m.ChannelID)
+	}
+}
+
+// Removes the conversation windows of deleted messages from an indexed channel
+func forgetDeletedHistory(channelID string, messageIDs []string) {
+	channelHistory, err := db.GetChannelHistory(channelID)
+	if err != nil {
+		log.Printf("Error getting channel history: %v", err)
+		return
+	}
+	if channelHistory == nil {
+		return
+	}
+	if err := db.DeleteHistoryWindows(context.Background(), *channelHistory, messageIDs); err != nil {
+		log.Printf("Error deleting history of messages %v: %v", messageIDs, err)
+	}
+}
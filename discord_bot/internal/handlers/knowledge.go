@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+)
+
+const KNOWLEDGE_BASE_CHUNKS = 5 // unless the channel sets a retrieval depth
+
+var knowledgeModeNames = map[string]string{
+	db.KnowledgeOnly:       "Knowledge base only",
+	db.KnowledgeAndGeneral: "Knowledge base + general knowledge",
+	db.GeneralOnly:         "General knowledge only",
+}
+
+// Answers a question outside of a document thread, from the server's
+// knowledge base and/or the model's general knowledge depending on the mode.
+// Also returns the knowledge base chunks the answer was given from.
+func answerFromKnowledgeBase(serverID string, question string, config db.LLMConfig) (string, []ai.RetrievedChunk, error) {
+	mode := knowledgeMode(serverID)
+
+	var empty_history []*discordgo.Message
+	if mode == db.GeneralOnly {
+		response, err := ai.LlmGenerateText(empty_history, question, "", config)
+		return response, nil, err
+	}
+
+	limit := KNOWLEDGE_BASE_CHUNKS
+	if config.RetrievalDepth > 0 {
+		limit = config.RetrievalDepth
+	}
+	sources, err := ai.SearchKnowledgeBase(context.Background(), question, serverID, limit)
+	if err != nil {
+		log.Println("Error searching knowledge base:", err)
+	}
+	res := ai.FormatChunks(sources)
+
+	if mode == db.KnowledgeOnly {
+		if res == "" {
+			return "I couldn't find anything about that in this server's knowledge base.", nil, nil
+		}
+		new_user_msg := fmt.Sprintf("Knowledge base:\n%s\n\nAnswer only from the knowledge base above. If it doesn't answer the question, say so.\n\nUser: %s", res, question)
+		response, err := ai.LlmGenerateText(empty_history, new_user_msg, "", config)
+		return response, sources, err
+	}
+
+	if res == "" {
+		response, err := ai.LlmGenerateText(empty_history, question, "", config)
+		return response, nil, err
+	}
+	new_user_msg := fmt.Sprintf("Knowledge base:\n%s\n\nUse the knowledge base above when it's relevant, otherwise answer from general knowledge.\n\nUser: %s", res, question)
+	response, err := ai.LlmGenerateText(empty_history, new_user_msg, "", config)
+	return response, sources, err
+}
+
+// The server's knowledge mode, the default if it can't be looked up
+func knowledgeMode(serverID string) string {
+	mode, err := db.GetKnowledgeMode(serverID)
+	if err != nil {
+		log.Printf("Error getting knowledge mode: %v", err)
+		return db.KnowledgeAndGeneral
+	}
+	return mode
+}
+
+func knowledgeCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		subcommand := i.ApplicationCommandData().Options[0]
+		if subcommand.Name == "list" {
+			respondEphemeral(s, i, knowledgeBaseSummary(i.GuildID))
+			return
+		}
+
+		guild, err := s.Guild(i.GuildID)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+		if i.Member.User.ID != guild.OwnerID {
+			respondEphemeral(s, i, "You are not the owner!")
+			return
+		}
+
+		ctx := context.Background()
+		var responseMessage string
+		switch subcommand.Name {
+		case "mode":
+			mode := subcommand.Options[0].StringValue()
+			err = db.UpdateKnowledgeMode(i.GuildID, mode)
+			responseMessage = fmt.Sprintf("✅ /ask and mentions now use: **%s**", knowledgeModeNames[mode])
+		case "add", "remove":
+			documentID, convErr := strconv.Atoi(subcommand.Options[0].StringValue())
+			if convErr != nil {
+				respondEphemeral(s, i, "Pick a document from the list.")
+				return
+			}
+			var found bool
+			if subcommand.Name == "add" {
+				found, err = db.AddKnowledgeDocument(ctx, i.GuildID, documentID, i.Member.User.ID)
+				responseMessage = "✅ Added to the knowledge base"
+			} else {
+				found, err = db.RemoveKnowledgeDocument(ctx, i.GuildID, documentID)
+				responseMessage = "✅ Removed from the knowledge base"
+			}
+			if err == nil && !found {
+				responseMessage = "🚨 That document doesn't exist or isn't ready yet."
+			}
+		}
+
+		if err != nil {
+			log.Printf("Error updating knowledge base: %v", err)
+			responseMessage = "🚨 Failed to update the knowledge base. Database error."
+		}
+		respondEphemeral(s, i, responseMessage)
+	}
+}
+
+func knowledgeBaseSummary(serverID string) string {
+	mode := knowledgeMode(serverID)
+	docs, err := db.GetKnowledgeDocuments(context.Background(), serverID)
+	if err != nil {
+		log.Printf("Error getting knowledge base: %v", err)
+		return "🚨 Failed to get the knowledge base. Database error."
+	}
+
+	lines := []string{fmt.Sprintf("📚 **Knowledge base** (%s)", knowledgeModeNames[mode])}
+	if len(docs) == 0 {
+		lines = append(lines, "Empty. The owner can pin documents with `/knowledge add`.")
+	}
+	for n, doc := range docs {
+		lines = append(lines, fmt.Sprintf("%d. [%s](https://discord.com/channels/%s/%s/%s)", n+1, doc.Title, serverID, doc.ChannelID, doc.MessageID))
+	}
+	summary := strings.Join(lines, "\n")
+	if runes := []rune(summary); len(runes) > MAX_MESSAGE_LENGTH {
+		summary = string(runes[:MAX_MESSAGE_LENGTH-3]) + "..."
+	}
+	return summary
+}
+
+// Short version of knowledgeBaseSummary that fits in an embed field
+func knowledgeBaseOverview(serverID string) string {
+	mode := knowledgeMode(serverID)
+	docs, err := db.GetKnowledgeDocuments(context.Background(), serverID)
+	if err != nil {
+		log.Printf("Error getting knowledge base: %v", err)
+	}
+	return fmt.Sprintf("**Mode:** %s\n**Documents:** %d, see `/knowledge list`", knowledgeModeNames[mode], len(docs))
+}
+
+// Suggests the server's documents for /knowledge add, and the pinned ones for /knowledge remove
+func knowledgeDocumentAutocomplete() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		// Discord drops autocomplete responses after 3 seconds
+		ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+		defer cancel()
+
+		subcommand := i.ApplicationCommandData().Options[0]
+		typed := focusedOptionValue(subcommand.Options)
+		var docs []db.Document
+		var err error
+		if subcommand.Name == "remove" {
+			docs, err = db.GetKnowledgeDocuments(ctx, i.GuildID)
+		} else {
+			docs, err = db.SearchServerDocuments(ctx, i.GuildID, typed, MAX_AUTOCOMPLETE_CHOICES)
+		}
+		if err != nil {
+			log.Printf("Error listing documents: %v", err)
+		}
+
+		err = respond(s, i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: documentChoices(docs, typed),
+			},
+		})
+		if err != nil {
+			log.Printf("Error responding to autocomplete: %v", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/reindex"
+)
+
+// Re-chunks and re-embeds the server's documents in the background, after an
+// embedding model or chunker upgrade
+func reindexCommand() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		guild, err := s.Guild(i.GuildID)
+		if err != nil {
+			log.Println("Error getting guild")
+			return
+		}
+		if i.Member.User.ID != guild.OwnerID {
+			respondEphemeral(s, i, "You are not the owner!")
+			return
+		}
+
+		ctx := context.Background()
+		switch i.ApplicationCommandData().Options[0].Name {
+		case "start":
+			run, err := reindex.Begin(ctx, i.GuildID, i.Member.User.ID)
+			if errors.Is(err, db.ErrReindexRunning) {
+				respondEphemeral(s, i, "A re-index is already running, check it with `/reindex status`.")
+				return
+			}
+			if err != nil {
+				log.Printf("Error starting re-index: %v", err)
+				respondEphemeral(s, i, "🚨 Failed to start the re-index. Database error.")
+				return
+			}
+			if run.Total == 0 {
+				if err := db.CompleteReindexRun(ctx, run); err != nil {
+					log.Printf("Error completing empty re-index run: %v", err)
+				}
+				respondEphemeral(s, i, "✅ Every document is already indexed with the current model and chunker.")
+				return
+			}
+			go func() {
+				if err := reindex.Run(context.Background(), run, nil); err != nil {
+					log.Printf("Error running re-index %d: %v", run.ID, err)
+				}
+			}()
+			respondEphemeral(s, i, fmt.Sprintf("🔁 Re-indexing %d documents in the background. Answers keep using the current index until it's done, check on it with `/reindex status`.", run.Total))
+		case "status":
+			run, err := db.GetLatestReindexRun(ctx, i.GuildID)
+			if err != nil {
+				log.Printf("Error getting re-index run: %v", err)
+				respondEphemeral(s, i, "🚨 Failed to get the re-index status. Database error.")
+				return
+			}
+			if run == nil {
+				respondEphemeral(s, i, "This server was never re-indexed.")
+				return
+			}
+			respondEphemeral(s, i, "🔁 Re-index "+reindex.Summary(*run))
+		case "cancel":
+			run, err := db.GetLatestReindexRun(ctx, i.GuildID)
+			if err != nil {
+				log.Printf("Error getting re-index run: %v", err)
+				respondEphemeral(s, i, "🚨 Failed to cancel the re-index. Database error.")
+				return
+			}
+			if run == nil || run.Status != db.ReindexRunning {
+				respondEphemeral(s, i, "No re-index is running.")
+				return
+			}
+			if err := db.StopReindexRun(ctx, run.ID, db.ReindexCancelled, ""); err != nil {
+				log.Printf("Error cancelling re-index run %d: %v", run.ID, err)
+				respondEphemeral(s, i, "🚨 Failed to cancel the re-index. Database error.")
+				return
+			}
+			respondEphemeral(s, i, "🛑 Re-index cancelled, answers keep using the current index.")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	AUTO_DEFER_AFTER = 2 * time.Second  // Discord drops interactions that aren't answered in 3 seconds
+	INTERACTION_TTL  = 15 * time.Minute // how long an interaction's token can send follow-ups
+)
+
+type route struct {
+	handle func(s *discordgo.Session, i *discordgo.InteractionCreate)
+	public bool // the deferred response is visible to everyone, not only the user
+	manual bool // never deferred, the handler responds in time itself, like when opening a modal
+}
+
+// Whether an interaction was answered yet, so responses after the router
+// deferred it edit the deferred response instead
+type interactionState struct {
+	mu       sync.Mutex
+	public   bool
+	deferred discordgo.InteractionResponseType // 0 until deferred
+	answered bool
+}
+
+var interactions sync.Map // interaction ID -> *interactionState
+
+// Dispatches interactions by type, and by custom ID up to the first ":" for
+// components and modals. Slow handlers are deferred, panics are recovered.
+func InteractionRouter() func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		var name string
+		var r route
+		var ok bool
+		switch i.Type {
+		case discordgo.InteractionApplicationCommand:
+			name = i.ApplicationCommandData().Name
+			r, ok = commandHandlers[name]
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			name = i.ApplicationCommandData().Name
+			r, ok = autocompleteHandlers[name]
+			r.manual = true // autocomplete can't be deferred
+		case discordgo.InteractionMessageComponent:
+			name, _, _ = strings.Cut(i.MessageComponentData().CustomID, ":")
+			r, ok = componentHandlers[name]
+		case discordgo.InteractionModalSubmit:
+			name, _, _ = strings.Cut(i.ModalSubmitData().CustomID, ":")
+			r, ok = modalHandlers[name]
+		}
+		if !ok {
+			log.Printf("No handler for interaction %s (type %d)", name, i.Type)
+			return
+		}
+
+		state := &interactionState{public: r.public}
+		interactions.Store(i.ID, state)
+		time.AfterFunc(INTERACTION_TTL, func() { interactions.Delete(i.ID) })
+
+		if !r.manual {
+			timer := time.AfterFunc(AUTO_DEFER_AFTER, func() {
+				if err := deferResponse(s, i); err != nil {
+					log.Printf("Error deferring %s: %v", name, err)
+				}
+			})
+			defer timer.Stop()
+		}
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("Panic handling %s: %v\n%s", name, err, debug.Stack())
+				if i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+					respondEphemeral(s, i, "🚨 Something went wrong. Try again later.")
+				}
+			}
+		}()
+		r.handle(s, i)
+	}
+}
+
+func stateOf(i *discordgo.InteractionCreate) *interactionState {
+	if state, ok := interactions.Load(i.ID); ok {
+		return state.(*interactionState)
+	}
+	// Not routed, like in a goroutine that outlived INTERACTION_TTL
+	return &interactionState{}
+}
+
+// Acknowledges the interaction so the handler has 15 minutes to respond, the
+// response is then sent with respond, InteractionResponseEdit or follow-ups.
+// Does nothing if it was already answered.
+func deferResponse(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	state := stateOf(i)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.answered {
+		return nil
+	}
+
+	response := &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}
+	if i.Type == discordgo.InteractionMessageComponent {
+		// Keeps the clicked message as is, anything else is sent as a follow-up
+		response.Type = discordgo.InteractionResponseDeferredMessageUpdate
+	} else if !state.public {
+		response.Data = &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral}
+	}
+	if err := s.InteractionRespond(i.Interaction, response); err != nil {
+		return err
+	}
+	state.answered = true
+	state.deferred = response.Type
+	return nil
+}
+
+// Responds to the interaction, or if it was deferred, sends the response as an
+// edit of the deferred response or a follow-up
+func respond(s *discordgo.Session, i *discordgo.InteractionCreate, response *discordgo.InteractionResponse) error {
+	state := stateOf(i)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.answered {
+		if err := s.InteractionRespond(i.Interaction, response); err != nil {
+			return err
+		}
+		state.answered = true
+		return nil
+	}
+
+	data := response.Data
+	if data == nil {
+		data = &discordgo.InteractionResponseData{}
+	}
+	switch {
+	case response.Type == discordgo.InteractionResponseModal, response.Type == discordgo.InteractionApplicationCommandAutocompleteResult:
+		return fmt.Errorf("interaction was already answered")
+	case state.deferred == discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		response.Type == discordgo.InteractionResponseUpdateMessage:
+		edit := &discordgo.WebhookEdit{Content: &data.Content, Files: data.Files, AllowedMentions: data.AllowedMentions}
+		if data.Components != nil {
+			edit.Components = &data.Components
+		}
+		if data.Embeds != nil {
+			edit.Embeds = &data.Embeds
+		}
+		_, err := s.InteractionResponseEdit(i.Interaction, edit)
+		return err
+	case response.Type == discordgo.InteractionResponseChannelMessageWithSource:
+		_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content:         data.Content,
+			Components:      data.Components,
+			Embeds:          data.Embeds,
+			Files:           data.Files,
+			AllowedMentions: data.AllowedMentions,
+			Flags:           data.Flags,
+		})
+		return err
+	}
+	return nil // deferring again
+}
+
+// The one way handlers reply only to the user, for errors and confirmations alike
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := respond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
@@ -2,26 +2,28 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/jobs"
 )
 
-type ExtractedTextResponse struct {
-	ExtractedText string `json:"extracted_text"`
-	FileSize      int    `json:"file_size"`
-}
-
-type ExtractedErrorResponse struct {
-	Error string `json:"error"`
-}
-
 const (
-	THREAD_LIMIT = 20
+	THREAD_LIMIT             = 20
+	MAX_AUTOCOMPLETE_CHOICES = 25
+	INGESTION_WAIT_TIMEOUT   = 10 * time.Minute
+	MAX_THREAD_NAME_LENGTH   = 100
+	MAX_MESSAGE_LENGTH       = 2000
 )
 
 func GetThreadMessages(s *discordgo.Session, threadID string, botID string) ([]*discordgo.Message, error) {
@@ -33,62 +35,363 @@ func GetThreadMessages(s *discordgo.Session, threadID string, botID string) ([]*
 	return msgs, nil
 }
 
-func getFileTextAndSize(pdfURL string) (string, int, error) {
-	payload := map[string]string{"file_url": pdfURL}
-	jsonPayload, err := json.Marshal(payload)
+// threadID is empty when the files are indexed without a thread
+func attachmentJobs(m *discordgo.Message, channelID string, threadID string) []jobs.Job {
+	var queued []jobs.Job
+	for i, attachment := range m.Attachments {
+		log.Printf("Attachment %d: %s (%s)", i, attachment.Filename, attachment.URL)
+		queued = append(queued, jobs.Job{
+			ID:         fmt.Sprintf("%s-%d", m.ID, i),
+			Kind:       jobs.KindFile,
+			MessageID:  m.ID,
+			GuildID:    m.GuildID,
+			ChannelID:  channelID,
+			ThreadID:   threadID,
+			UploaderID: m.Author.ID,
+			URL:        attachment.URL,
+			Filename:   attachment.Filename,
+			Size:       attachment.Size,
+		})
+	}
+	return queued
+}
+
+// messageID is the message the thread starts from, chunks are stored under it
+func linkJobs(messageID string, guildID string, uploaderID string, channelID string, threadID string, links []string) []jobs.Job {
+	var queued []jobs.Job
+	for i, link := range links {
+		queued = append(queued, jobs.Job{
+			ID:         fmt.Sprintf("%s-link-%d", messageID, i),
+			Kind:       jobs.KindWebPage,
+			MessageID:  messageID,
+			GuildID:    guildID,
+			ChannelID:  channelID,
+			ThreadID:   threadID,
+			UploaderID: uploaderID,
+			URL:        link,
+			Filename:   link,
+		})
+	}
+	return queued
+}
+
+// Usage of the owner's plan, nil if it couldn't be looked up
+func ownerUsage(ownerID string) *db.OwnerUsage {
+	usage, err := db.GetOwnerUsage(ownerID)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to create JSON payload: %v", err)
+		log.Printf("Error getting owners limits: %v", err)
+		return nil
 	}
+	return &usage
+}
 
-	PARSER_API_URL := fmt.Sprintf("%s/extract_text", os.Getenv("PARSER_API_URL"))
-	resp, err := http.Post(PARSER_API_URL, "application/json", bytes.NewBuffer(jsonPayload))
+// The checks uploads go through, for interactions that start threads: the
+// channel is allowed, the user isn't banned and the owner has messages left.
+// Returns the owner's usage for applyUploadQuota, and why the interaction
+// isn't allowed, empty if it is.
+func checkInteractionAllowed(s *discordgo.Session, i *discordgo.InteractionCreate, channelID string) (*db.OwnerUsage, string) {
+	allowedChannels, err := db.GetAllowedChannels(i.GuildID)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to make request: %v", err)
+		log.Printf("Error fetching allowed channels: %v", err)
+		return nil, "Server error. Try again later."
 	}
-	defer resp.Body.Close()
+	if !slices.Contains(allowedChannels, channelID) {
+		return nil, "Intellicord isn't enabled in this channel. An admin can add it with /addchannel"
+	}
+	if banned := db.BanCheck(discordgo.MessageCreate{Message: &discordgo.Message{Author: i.Member.User, GuildID: i.GuildID}}); banned != "" {
+		return nil, banned
+	}
+	guild, err := s.Guild(i.GuildID)
+	if err != nil {
+		log.Printf("Error getting guild: %v", err)
+		return nil, "Server error. Try again later."
+	}
+	usage := ownerUsage(guild.OwnerID)
+	if usage != nil && usage.MessageLimitReached() {
+		return nil, "Maximum message limit reached. Upgrade for more messages"
+	}
+	return usage, ""
+}
+
+// Drops the jobs that would go over the plan's upload limits, before anything
+// is downloaded. Returns the jobs that fit and a message explaining what was
+// skipped, empty if nothing was. With no usage everything is let through.
+func applyUploadQuota(usage *db.OwnerUsage, queued []jobs.Job) ([]jobs.Job, string) {
+	if usage == nil {
+		return queued, ""
+	}
+	uploads := usage.FileUploads
+	stored := usage.StoredBytes
+	limits := usage.Limits
+
+	var allowed []jobs.Job
+	var skipped []string
+	for _, job := range queued {
+		reason := ""
+		switch {
+		case int64(job.Size) > limits.MaxFileSize:
+			reason = fmt.Sprintf("it's over the %s file size limit", db.FormatBytes(limits.MaxFileSize))
+		case uploads >= limits.MaxFileUploads:
+			reason = "the monthly upload limit has been reached"
+		case stored+int64(job.Size) > limits.MaxStorageBytes:
+			reason = "there isn't enough storage left"
+		}
+		if reason != "" {
+			skipped = append(skipped, fmt.Sprintf("⚠️ Skipped '%s': %s", job.Filename, reason))
+			continue
+		}
+		uploads++
+		stored += int64(job.Size)
+		allowed = append(allowed, job)
+	}
+	if len(skipped) == 0 {
+		return allowed, ""
+	}
+	notice := fmt.Sprintf("%s\n-# Usage: %s. Upgrade for more.", strings.Join(skipped, "\n"), usage.Summary())
+	return allowed, notice
+}
+
+// Host and path of the link, cut to fit a thread name
+func linkThreadName(link string) string {
+	name := link
+	if u, err := url.Parse(link); err == nil && u.Host != "" {
+		name = strings.TrimSuffix(u.Host+u.Path, "/")
+	}
+	if runes := []rune(name); len(runes) > MAX_THREAD_NAME_LENGTH {
+		name = string(runes[:MAX_THREAD_NAME_LENGTH])
+	}
+	return name
+}
 
-	body, err := io.ReadAll(resp.Body)
+// Queues the jobs with a progress message for each in progressChannelID.
+// Returns the IDs of the jobs that were queued.
+func enqueueJobs(s *discordgo.Session, queued []jobs.Job, progressChannelID string) []string {
+	var jobIDs []string
+	for _, job := range queued {
+		var progressMessage *discordgo.Message
+		if progressChannelID != "" {
+			var err error
+			progressMessage, err = s.ChannelMessageSend(progressChannelID, fmt.Sprintf("-# ⏳ Queued: %s", job.Filename))
+			if err != nil {
+				log.Printf("Error sending progress message: %v", err)
+			} else {
+				job.ProgressChannelID = progressChannelID
+				job.ProgressMessageID = progressMessage.ID
+			}
+		}
+		if err := jobs.Enqueue(context.Background(), job); err != nil {
+			log.Printf("Error queueing '%s': %v", job.Filename, err)
+			if progressMessage != nil {
+				s.ChannelMessageEdit(progressChannelID, progressMessage.ID, fmt.Sprintf("-# 🚨 There was an error processing '%s'", job.Filename))
+			}
+			continue
+		}
+		jobIDs = append(jobIDs, job.ID)
+	}
+	return jobIDs
+}
+
+// Answers the message in the thread once its files are indexed
+func answerAfterIngestion(s *discordgo.Session, m *discordgo.MessageCreate, threadID string, config db.LLMConfig, jobIDs []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), INGESTION_WAIT_TIMEOUT)
+	defer cancel()
+	if _, err := jobs.Wait(ctx, jobIDs); err != nil {
+		log.Printf("Error waiting for files of message %s: %v", m.ID, err)
+	}
+
+	s.ChannelTyping(threadID)
+	go db.AddMessageLog(m.Message.ID, m.GuildID, m.ChannelID, m.Author.ID)
+
+	sources, err := ai.SearchChunks(context.Background(), m.Content, []string{m.ID}, config.RetrievalLimit(len(jobIDs)))
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to read response: %v", err)
+		log.Println("Error searching chunks:", err)
+	}
+
+	var empty_history []*discordgo.Message
+	new_user_msg := fmt.Sprintf("Context:\n%s\n\n%s: %s", ai.FormatChunks(sources), m.Author.Username, m.Content)
+	response, err := ai.LlmGenerateText(empty_history, new_user_msg, s.State.User.ID, config)
+	if err != nil {
+		sendResponseInChannel(s, threadID, "Server error. Try again later.")
+		return
 	}
+	messageIDs := sendAnswerInChannel(s, threadID, response, m.Attachments)
+	recordAnswer(m.Message, m.Message.ID, threadID, messageIDs, config, sources)
+}
+
+// Indexes files posted in a bot thread as part of the thread's documents and
+// returns the queued jobs, without waiting for them
+func addThreadAttachments(s *discordgo.Session, m *discordgo.MessageCreate, thread *discordgo.Channel, usage *db.OwnerUsage) []string {
+	threadID := thread.ID
+	queued := attachmentJobs(m.Message, thread.ParentID, threadID)
+	tags := forumPostTags(s, thread)
+	for n := range queued {
+		queued[n].Tags = tags
+	}
+	queued, notice := applyUploadQuota(usage, queued)
+	if notice != "" {
+		sendResponseInChannel(s, threadID, notice)
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+	if err := db.AddThreadDocument(threadID, m.ID, m.GuildID); err != nil {
+		log.Printf("Error adding thread document: %v", err)
+		sendResponseInChannel(s, threadID, "There was an error adding the file to this thread.")
+		return nil
+	}
+	return enqueueJobs(s, queued, threadID)
+}
+
+// Indexes without a thread and reacts to the message when done
+func indexSilently(s *discordgo.Session, m *discordgo.MessageCreate, queued []jobs.Job) {
+	jobIDs := enqueueJobs(s, queued, "")
 
-	if resp.StatusCode != http.StatusOK {
-		var result ExtractedErrorResponse
-		if err := json.Unmarshal(body, &result); err != nil {
-			return "", 0, fmt.Errorf("failed to parse JSON response: %v", err)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), INGESTION_WAIT_TIMEOUT)
+		defer cancel()
+		results, err := jobs.Wait(ctx, jobIDs)
+		if err != nil {
+			log.Printf("Error waiting for files of message %s: %v", m.ID, err)
 		}
-		return "", 0, fmt.Errorf("%s", result.Error)
+
+		indexed := 0
+		for _, job := range results {
+			if job.Status == jobs.StatusReady {
+				indexed++
+			}
+		}
+		reaction := "✅"
+		if indexed < len(queued) {
+			reaction = "⚠️"
+		}
+		if err := s.MessageReactionAdd(m.ChannelID, m.ID, reaction); err != nil {
+			log.Printf("Error reacting to message: %v", err)
+		}
+	}()
+}
+
+// Whether deleted messages of the channel could have anything stored, so
+// deletions elsewhere skip the per-message lookups. True for allowed channels,
+// threads in them and channels that still have documents.
+func mayHaveIndexedMessages(s *discordgo.Session, guildID string, channelID string) bool {
+	allowedChannels, err := db.GetAllowedChannels(guildID)
+	if err != nil {
+		log.Printf("Error fetching allowed channels: %v", err)
+		return true
+	}
+	if slices.Contains(allowedChannels, channelID) {
+		return true
+	}
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		channel, err = s.Channel(channelID)
+	}
+	if err == nil && channel.IsThread() && slices.Contains(allowedChannels, channel.ParentID) {
+		return true
+	}
+	found, err := db.ChannelHasDocuments(context.Background(), guildID, channelID)
+	if err != nil {
+		log.Printf("Error checking for documents in channel %s: %v", channelID, err)
+		return true
+	}
+	return found
+}
+
+// Removes everything stored for deleted messages that were indexed or still
+// being indexed, and tells their threads. A thread whose root message was
+// deleted has nothing left to answer from, so it's archived and locked.
+func cleanUpDeletedMessages(s *discordgo.Session, messageIDs []string) {
+	ctx := context.Background()
+	pending, err := jobs.CancelMessageJobs(ctx, messageIDs)
+	if err != nil {
+		log.Printf("Error cancelling ingestion jobs: %v", err)
+	}
+	indexed, err := db.GetIndexedMessages(ctx, messageIDs)
+	if err != nil {
+		log.Printf("Error checking for indexed messages: %v", err)
+		return
+	}
+	deleted := append(indexed, pending...)
+	if len(deleted) == 0 {
+		return
 	}
 
-	var result ExtractedTextResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", 0, fmt.Errorf("failed to parse JSON response: %v", err)
+	threadIDs, err := db.DeleteMessageDocuments(ctx, deleted)
+	if err != nil {
+		log.Printf("Error deleting documents of messages %v: %v", deleted, err)
+		return
 	}
 
-	return result.ExtractedText, result.FileSize, nil
+	// A thread started from a message has the message's ID
+	threadIDs = append(threadIDs, deleted...)
+	slices.Sort(threadIDs)
+	for _, threadID := range slices.Compact(threadIDs) {
+		thread, err := s.Channel(threadID)
+		if err != nil || !thread.IsThread() || (thread.OwnerID != s.State.User.ID && !isAllowedForumPost(s, thread)) {
+			continue
+		}
+		if !slices.Contains(deleted, threadID) {
+			sendResponseInChannel(s, threadID, "-# 🗑️ A file in this thread was deleted, answers won't use it anymore.")
+			continue
+		}
+		sendResponseInChannel(s, threadID, "-# 🗑️ The original message was deleted along with its files. This thread is now closed.")
+		archived, locked := true, true
+		_, err = s.ChannelEditComplex(threadID, &discordgo.ChannelEdit{Archived: &archived, Locked: &locked})
+		if err != nil {
+			log.Printf("Error archiving thread %s: %v", threadID, err)
+		}
+	}
 }
 
 func sendResponseInChannel(session *discordgo.Session, channelID string, response string) {
-	if len(response) >= 2000 {
-		msg := ""
-		for ind, char := range response {
-			if ind != 0 && ind%2000 == 0 {
-				session.ChannelMessageSend(channelID, msg)
-				msg = ""
-			} else {
-				msg += string(char)
-			}
+	for _, piece := range splitMessage(response) {
+		session.ChannelMessageSend(channelID, piece)
+	}
+}
+
+// Splits text into pieces that fit in a Discord message
+func splitMessage(text string) []string {
+	var pieces []string
+	runes := []rune(text)
+	for len(runes) > MAX_MESSAGE_LENGTH {
+		pieces = append(pieces, string(runes[:MAX_MESSAGE_LENGTH]))
+		runes = runes[MAX_MESSAGE_LENGTH:]
+	}
+	if len(runes) > 0 {
+		pieces = append(pieces, string(runes))
+	}
+	return pieces
+}
+
+// The bot's messages after questionID, oldest first, if nobody has posted since.
+// Progress messages for files posted with the question aren't part of the answer.
+func getAnswerToLatestQuestion(s *discordgo.Session, threadID string, questionID string) ([]*discordgo.Message, error) {
+	after, err := s.ChannelMessages(threadID, THREAD_LIMIT, "", questionID, "")
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(after, func(a, b *discordgo.Message) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	var answer []*discordgo.Message
+	for _, msg := range after {
+		if msg.Author == nil || msg.Author.ID != s.State.User.ID {
+			return nil, nil
 		}
-		if msg != "" {
-			session.ChannelMessageSend(channelID, msg)
+		if strings.HasPrefix(msg.Content, "-# ") {
+			continue
 		}
-	} else {
-		session.ChannelMessageSend(channelID, response)
+		answer = append(answer, msg)
 	}
+	return answer, nil
 }
 
 func getRootMessageOfThread(s *discordgo.Session, channel *discordgo.Channel) (message *discordgo.Message, err error) {
 	parentMessage, err := s.ChannelMessage(channel.ParentID, channel.ID)
+	if err != nil {
+		// A forum post's first message is in the post itself
+		parentMessage, err = s.ChannelMessage(channel.ID, channel.ID)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +405,73 @@ func getRootMessageOfThread(s *discordgo.Session, channel *discordgo.Channel) (m
 	return parentMessage, nil
 }
 
+// Whether the bot answers every message in the thread: threads it started,
+// and forum posts in allowed forums that have documents
+func botAnswersInThread(s *discordgo.Session, channel *discordgo.Channel) bool {
+	if !channel.IsThread() {
+		return false
+	}
+	if channel.OwnerID == s.State.User.ID {
+		return true
+	}
+	if !isAllowedForumPost(s, channel) {
+		return false
+	}
+	threadDocuments, err := db.GetThreadDocuments(channel.ID)
+	if err != nil {
+		log.Printf("Error getting thread documents: %v", err)
+	}
+	return len(threadDocuments) > 0
+}
+
+func isAllowedForumPost(s *discordgo.Session, channel *discordgo.Channel) bool {
+	if !channel.IsThread() {
+		return false
+	}
+	parent, err := s.Channel(channel.ParentID)
+	if err != nil || parent.Type != discordgo.ChannelTypeGuildForum {
+		return false
+	}
+	allowedChannels, err := db.GetAllowedChannels(channel.GuildID)
+	if err != nil {
+		log.Printf("Error fetching allowed channels: %v", err)
+		return false
+	}
+	return slices.Contains(allowedChannels, parent.ID)
+}
+
+// Names of the tags applied to a forum post, nil for other threads
+func forumPostTags(s *discordgo.Session, channel *discordgo.Channel) []string {
+	if len(channel.AppliedTags) == 0 {
+		return nil
+	}
+	parent, err := s.Channel(channel.ParentID)
+	if err != nil {
+		log.Printf("Error fetching forum channel: %v", err)
+		return nil
+	}
+	var tags []string
+	for _, tag := range parent.AvailableTags {
+		if slices.Contains(channel.AppliedTags, tag.ID) {
+			tags = append(tags, tag.Name)
+		}
+	}
+	return tags
+}
+
+// Forum posts can't run commands in the forum itself, so a command in a post applies to its forum
+func commandChannelID(s *discordgo.Session, i *discordgo.InteractionCreate) string {
+	channel, err := s.Channel(i.ChannelID)
+	if err != nil || !channel.IsThread() {
+		return i.ChannelID
+	}
+	parent, err := s.Channel(channel.ParentID)
+	if err != nil || parent.Type != discordgo.ChannelTypeGuildForum {
+		return i.ChannelID
+	}
+	return parent.ID
+}
+
 func NewDiscordWebhookMessage(webhookURL string, message string) {
 	payload := map[string]string{
 		"content": message,
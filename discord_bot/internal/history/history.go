@@ -0,0 +1,235 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+)
+
+const (
+	DIRTY_KEY             = "history:dirty" // channels with messages that aren't indexed yet
+	MAX_BACKFILL_MESSAGES = 5000
+	PAGE_SIZE             = 100
+	PAGE_DELAY            = time.Second // on top of discordgo's own rate limiting
+	SYNC_INTERVAL         = 5 * time.Minute
+	WINDOW_GAP            = 30 * time.Minute // a longer pause starts a new conversation
+	WINDOW_MAX_MESSAGES   = 30
+	WINDOW_MAX_CHARS      = 3000
+)
+
+// Consecutive messages of one conversation, oldest first
+type window []*discordgo.Message
+
+// Starts syncing new messages of indexed channels
+func Start(s *discordgo.Session) {
+	go syncLoop(s)
+}
+
+// Marks an indexed channel as having new messages, they're indexed on the next sync
+func MessagePosted(channelID string) {
+	history, err := db.GetChannelHistory(channelID)
+	if err != nil {
+		log.Printf("Error getting channel history: %v", err)
+		return
+	}
+	if history == nil || history.LastMessageID == "" {
+		return
+	}
+	if err := db.RedisClient.SAdd(context.Background(), DIRTY_KEY, channelID).Err(); err != nil {
+		log.Printf("Error marking channel %s for sync: %v", channelID, err)
+	}
+}
+
+func Title(channelName string) string {
+	return fmt.Sprintf("#%s history", channelName)
+}
+
+// Indexes the channel's newest MAX_BACKFILL_MESSAGES messages. Messages after
+// those are picked up by the sync. progress is called after every page.
+func Backfill(ctx context.Context, s *discordgo.Session, history db.ChannelHistory, title string, progress func(read int)) (int, error) {
+	var messages []*discordgo.Message
+	before := ""
+	for len(messages) < MAX_BACKFILL_MESSAGES {
+		page, err := s.ChannelMessages(history.ChannelID, PAGE_SIZE, before, "", "")
+		if err != nil {
+			return 0, fmt.Errorf("failed to read messages: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		messages = append(messages, page...)
+		before = page[len(page)-1].ID
+		progress(len(messages))
+		if len(page) < PAGE_SIZE {
+			break
+		}
+		time.Sleep(PAGE_DELAY)
+	}
+	sortOldestFirst(messages)
+
+	windows := groupWindows(messages)
+	stored := storeWindows(ctx, history, title, windows)
+
+	history.LastMessageID = "0"
+	if len(messages) > 0 {
+		history.LastMessageID = messages[len(messages)-1].ID
+	}
+	if err := db.SetChannelHistoryCursor(ctx, history); err != nil {
+		return stored, err
+	}
+	if err := db.SetDocumentStatus(ctx, history.DocumentID, db.DocumentReady); err != nil {
+		log.Printf("Error updating document status: %v", err)
+	}
+	return stored, nil
+}
+
+func syncLoop(s *discordgo.Session) {
+	ctx := context.Background()
+	ticker := time.NewTicker(SYNC_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		channelIDs, err := db.RedisClient.SMembers(ctx, DIRTY_KEY).Result()
+		if err != nil {
+			log.Printf("Error reading channels to sync: %v", err)
+			continue
+		}
+		for _, channelID := range channelIDs {
+			db.RedisClient.SRem(ctx, DIRTY_KEY, channelID)
+			if err := syncChannel(ctx, s, channelID); err != nil {
+				log.Printf("Error syncing history of channel %s: %v", channelID, err)
+			}
+		}
+	}
+}
+
+// Indexes the messages posted since the last sync. A conversation that's
+// still going on is left for the next one.
+func syncChannel(ctx context.Context, s *discordgo.Session, channelID string) error {
+	history, err := db.GetChannelHistory(channelID)
+	if err != nil || history == nil || history.LastMessageID == "" {
+		return err
+	}
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		return err
+	}
+
+	var messages []*discordgo.Message
+	after := history.LastMessageID
+	for len(messages) < MAX_BACKFILL_MESSAGES {
+		page, err := s.ChannelMessages(channelID, PAGE_SIZE, "", after, "")
+		if err != nil {
+			return fmt.Errorf("failed to read messages: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		sortOldestFirst(page)
+		messages = append(messages, page...)
+		after = page[len(page)-1].ID
+		if len(page) < PAGE_SIZE {
+			break
+		}
+		time.Sleep(PAGE_DELAY)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	windows := groupWindows(messages)
+	cursor := messages[len(messages)-1].ID
+	if len(windows) > 0 {
+		last := windows[len(windows)-1]
+		if time.Since(last[len(last)-1].Timestamp) < WINDOW_GAP {
+			windows = windows[:len(windows)-1]
+			cursor = justBefore(last[0].ID)
+			db.RedisClient.SAdd(ctx, DIRTY_KEY, channelID)
+		}
+	}
+	stored := storeWindows(ctx, *history, Title(channel.Name), windows)
+	log.Printf("Indexed %d new conversations in channel %s", stored, channelID)
+
+	history.LastMessageID = cursor
+	return db.SetChannelHistoryCursor(ctx, *history)
+}
+
+func storeWindows(ctx context.Context, history db.ChannelHistory, title string, windows []window) int {
+	stored := 0
+	for _, w := range windows {
+		first := w[0]
+		jumpLink := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", history.ServerID, history.ChannelID, first.ID)
+		err := ai.EmbedHistoryWindow(ctx, history.DocumentID, first.ID, w.text(title), jumpLink, title, history.ServerID)
+		if err != nil {
+			log.Printf("Error embedding conversation at %s: %v", jumpLink, err)
+			continue
+		}
+		stored++
+	}
+	return stored
+}
+
+// Splits messages into conversations on long pauses, and on size
+func groupWindows(messages []*discordgo.Message) []window {
+	var windows []window
+	var current window
+	chars := 0
+	for _, m := range messages {
+		if !isConversation(m) {
+			continue
+		}
+		if len(current) > 0 {
+			previous := current[len(current)-1]
+			if m.Timestamp.Sub(previous.Timestamp) > WINDOW_GAP || len(current) == WINDOW_MAX_MESSAGES || chars+len(m.Content) > WINDOW_MAX_CHARS {
+				windows = append(windows, current)
+				current, chars = nil, 0
+			}
+		}
+		current = append(current, m)
+		chars += len(m.Content)
+	}
+	if len(current) > 0 {
+		windows = append(windows, current)
+	}
+	return windows
+}
+
+// Messages people wrote, not bots or join and pin notices
+func isConversation(m *discordgo.Message) bool {
+	if m.Author == nil || m.Author.Bot || strings.TrimSpace(m.Content) == "" {
+		return false
+	}
+	return m.Type == discordgo.MessageTypeDefault || m.Type == discordgo.MessageTypeReply
+}
+
+// Date and channel, then one line per message with its author
+func (w window) text(title string) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s, %s\n", title, w[0].Timestamp.UTC().Format("2006-01-02 15:04 UTC"))
+	for _, m := range w {
+		fmt.Fprintf(&text, "%s: %s\n", m.Author.Username, m.Content)
+	}
+	return text.String()
+}
+
+func sortOldestFirst(messages []*discordgo.Message) {
+	slices.SortFunc(messages, func(a, b *discordgo.Message) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+}
+
+// A message ID to read after that includes the message itself
+func justBefore(messageID string) string {
+	id, err := strconv.ParseUint(messageID, 10, 64)
+	if err != nil || id == 0 {
+		return messageID
+	}
+	return strconv.FormatUint(id-1, 10)
+}
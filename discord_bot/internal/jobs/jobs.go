@@ -0,0 +1,385 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+	"github.com/matthewgaim/intellicord/internal/extract"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusParsing   = "parsing"
+	StatusEmbedding = "embedding"
+	StatusReady     = "ready"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled" // the message was deleted
+)
+
+const (
+	KindFile    = "file"
+	KindWebPage = "webpage"
+)
+
+const (
+	QUEUE_KEY       = "ingest:queue"
+	PROCESSING_KEY  = "ingest:processing"
+	DELAYED_KEY     = "ingest:delayed"
+	DEFAULT_WORKERS = 4
+	MAX_ATTEMPTS    = 4
+	BASE_BACKOFF    = 5 * time.Second
+	JOB_TTL         = 7 * 24 * time.Hour
+	POLL_INTERVAL   = time.Second
+	DEQUEUE_TIMEOUT = 5 * time.Second
+)
+
+var errStorageFull = errors.New("page doesn't fit in the plan's storage")
+
+// One attachment or web page to download, parse and embed
+type Job struct {
+	ID                string    `json:"id"`
+	Kind              string    `json:"kind,omitempty"` // empty = KindFile
+	MessageID         string    `json:"message_id"`     // chunks are stored under this ID
+	GuildID           string    `json:"guild_id"`
+	ChannelID         string    `json:"channel_id"`
+	ThreadID          string    `json:"thread_id,omitempty"`
+	UploaderID        string    `json:"uploader_id"`
+	URL               string    `json:"url"`
+	Filename          string    `json:"filename"`
+	Size              int       `json:"size,omitempty"`                // attachment size, 0 for web pages
+	Tags              []string  `json:"tags,omitempty"`                // forum tags of the post
+	ProgressChannelID string    `json:"progress_channel_id,omitempty"` // empty = no progress message
+	ProgressMessageID string    `json:"progress_message_id,omitempty"`
+	Status            string    `json:"status"`
+	Attempts          int       `json:"attempts"`
+	Error             string    `json:"error,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func (j Job) Done() bool {
+	return j.Status == StatusReady || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+func jobKey(id string) string {
+	return fmt.Sprintf("ingest:job:%s", id)
+}
+
+// IDs of the jobs queued for a message
+func messageJobsKey(messageID string) string {
+	return fmt.Sprintf("ingest:message:%s", messageID)
+}
+
+func cancelledKey(messageID string) string {
+	return fmt.Sprintf("ingest:cancelled:%s", messageID)
+}
+
+// Starts the worker pool. Jobs left in the processing list by a previous
+// run (i.e. the bot restarted mid-job) are queued again first.
+// Assumes a single bot instance consumes the queue.
+func Start(s *discordgo.Session) {
+	ctx := context.Background()
+	for {
+		id, err := db.RedisClient.LMove(ctx, PROCESSING_KEY, QUEUE_KEY, "RIGHT", "LEFT").Result()
+		if err != nil {
+			break
+		}
+		log.Printf("Requeued interrupted ingestion job %s", id)
+	}
+
+	workers := DEFAULT_WORKERS
+	if n, err := strconv.Atoi(os.Getenv("INGEST_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+	log.Printf("Starting %d ingestion workers", workers)
+	for w := 0; w < workers; w++ {
+		go worker(s)
+	}
+	go scheduleDelayed()
+}
+
+// Saves the job and adds it to the queue
+func Enqueue(ctx context.Context, job Job) error {
+	job.Status = StatusQueued
+	job.CreatedAt = time.Now()
+	if err := save(ctx, &job); err != nil {
+		return err
+	}
+	key := messageJobsKey(job.MessageID)
+	if err := db.RedisClient.SAdd(ctx, key, job.ID).Err(); err != nil {
+		return err
+	}
+	db.RedisClient.Expire(ctx, key, JOB_TTL)
+	return db.RedisClient.LPush(ctx, QUEUE_KEY, job.ID).Err()
+}
+
+// Stops the unfinished jobs of deleted messages. Workers skip them, and drop
+// what they stored if the message is deleted mid-job. Returns the messages
+// that had unfinished jobs.
+func CancelMessageJobs(ctx context.Context, messageIDs []string) ([]string, error) {
+	var cancelled []string
+	for _, messageID := range messageIDs {
+		ids, err := db.RedisClient.SMembers(ctx, messageJobsKey(messageID)).Result()
+		if err != nil {
+			return cancelled, err
+		}
+		for _, id := range ids {
+			if job, err := Get(ctx, id); err == nil && !job.Done() {
+				cancelled = append(cancelled, messageID)
+				break
+			}
+		}
+	}
+	for _, messageID := range cancelled {
+		if err := db.RedisClient.Set(ctx, cancelledKey(messageID), 1, JOB_TTL).Err(); err != nil {
+			return cancelled, err
+		}
+		log.Printf("Cancelled ingestion jobs of deleted message %s", messageID)
+	}
+	return cancelled, nil
+}
+
+func Get(ctx context.Context, id string) (Job, error) {
+	var job Job
+	data, err := db.RedisClient.Get(ctx, jobKey(id)).Bytes()
+	if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal(data, &job)
+	return job, err
+}
+
+// Blocks until every job is ready or failed, or ctx is done
+func Wait(ctx context.Context, ids []string) ([]Job, error) {
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		var result []Job
+		done := true
+		for _, id := range ids {
+			job, err := Get(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			done = done && job.Done()
+			result = append(result, job)
+		}
+		if done {
+			return result, nil
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func worker(s *discordgo.Session) {
+	ctx := context.Background()
+	for {
+		id, err := db.RedisClient.BLMove(ctx, QUEUE_KEY, PROCESSING_KEY, "RIGHT", "LEFT", DEQUEUE_TIMEOUT).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error dequeuing ingestion job: %v", err)
+			time.Sleep(DEQUEUE_TIMEOUT)
+			continue
+		}
+
+		job, err := Get(ctx, id)
+		if err != nil {
+			log.Printf("Error loading ingestion job %s: %v", id, err)
+		} else {
+			process(ctx, s, job)
+		}
+		db.RedisClient.LRem(ctx, PROCESSING_KEY, 1, id)
+	}
+}
+
+func process(ctx context.Context, s *discordgo.Session, job Job) {
+	if cancelled(ctx, &job) {
+		return
+	}
+	job.Attempts++
+	setStatus(ctx, s, &job, StatusParsing, fmt.Sprintf("-# 🔎 Reading file: %s", job.Filename))
+	var extracted extract.Result
+	if job.Kind == KindWebPage {
+		page, err := extract.FetchWebPage(ctx, job.URL)
+		if err != nil {
+			fail(ctx, s, job, err)
+			return
+		}
+		extracted = extract.Result{Text: page.Text, Size: page.Size, MimeType: "text/html"}
+		job.Filename = page.Title
+		if err := checkPageQuota(job, page.Size); err != nil {
+			fail(ctx, s, job, err)
+			return
+		}
+	} else {
+		var err error
+		extracted, err = extract.FromURL(ctx, job.URL, job.Filename)
+		if err != nil {
+			fail(ctx, s, job, err)
+			return
+		}
+	}
+
+	if cancelled(ctx, &job) {
+		return
+	}
+	setStatus(ctx, s, &job, StatusEmbedding, fmt.Sprintf("-# 🧠 Indexing file: %s", job.Filename))
+	err := ai.ChunkAndEmbed(ctx, db.Document{
+		ServerID:   job.GuildID,
+		ChannelID:  job.ChannelID,
+		ThreadID:   job.ThreadID,
+		MessageID:  job.MessageID,
+		UploaderID: job.UploaderID,
+		Title:      job.Filename,
+		SourceURL:  job.URL,
+		Tags:       job.Tags,
+		JobID:      job.ID,
+	}, extracted)
+	if err != nil {
+		fail(ctx, s, job, err)
+		return
+	}
+	// Deleted while embedding, the cleanup ran before there was anything to delete
+	if cancelled(ctx, &job) {
+		if _, err := db.DeleteMessageDocuments(ctx, []string{job.MessageID}); err != nil {
+			log.Printf("Error deleting documents of cancelled job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	setStatus(ctx, s, &job, StatusReady, fmt.Sprintf("-# ✅ File '%s' is ready!", job.Filename))
+}
+
+// Retries with exponential backoff, unless the error can't go away by retrying
+func fail(ctx context.Context, s *discordgo.Session, job Job, err error) {
+	job.Error = err.Error()
+	switch {
+	case errors.Is(err, extract.ErrUnsupportedType):
+		setStatus(ctx, s, &job, StatusFailed, fmt.Sprintf("-# 🚨 File '%s' is an unsupported file type. It wont be analyzed.", job.Filename))
+		return
+	case errors.Is(err, extract.ErrTooLarge), errors.Is(err, extract.ErrArchiveLimit):
+		setStatus(ctx, s, &job, StatusFailed, fmt.Sprintf("-# 🚨 File '%s' is too big.", job.Filename))
+		return
+	case errors.Is(err, errStorageFull):
+		setStatus(ctx, s, &job, StatusFailed, fmt.Sprintf("-# 🚨 Page '%s' doesn't fit in the storage left. Upgrade for more.", job.Filename))
+		return
+	case errors.Is(err, extract.ErrInvalidURL), errors.Is(err, extract.ErrBlockedAddress), errors.Is(err, extract.ErrTooManyRedirects):
+		setStatus(ctx, s, &job, StatusFailed, fmt.Sprintf("-# 🚨 Link '%s' can't be fetched.", job.URL))
+		return
+	}
+
+	log.Printf("Ingestion job %s failed (attempt %d/%d): %v", job.ID, job.Attempts, MAX_ATTEMPTS, err)
+	if job.Attempts >= MAX_ATTEMPTS {
+		setStatus(ctx, s, &job, StatusFailed, fmt.Sprintf("-# 🚨 There was an error processing file '%s'", job.Filename))
+		return
+	}
+
+	backoff := BASE_BACKOFF * time.Duration(1<<(job.Attempts-1))
+	setStatus(ctx, s, &job, StatusQueued, fmt.Sprintf("-# 🔁 Couldn't read '%s', retrying in %s", job.Filename, backoff))
+	err = db.RedisClient.ZAdd(ctx, DELAYED_KEY, redis.Z{
+		Score:  float64(time.Now().Add(backoff).Unix()),
+		Member: job.ID,
+	}).Err()
+	if err != nil {
+		log.Printf("Error scheduling retry for job %s: %v", job.ID, err)
+	}
+}
+
+// Web pages aren't sized until they're fetched, so unlike attachments they're
+// checked against the owner's plan here instead of when queued
+func checkPageQuota(job Job, size int) error {
+	ownerID, err := db.GetServerOwnerID(job.GuildID)
+	if err != nil {
+		log.Printf("Error getting owner of server %s: %v", job.GuildID, err)
+		return nil
+	}
+	usage, err := db.GetOwnerUsage(ownerID)
+	if err != nil {
+		log.Printf("Error getting owners limits: %v", err)
+		return nil
+	}
+	if int64(size) > usage.Limits.MaxFileSize || usage.StoredBytes+int64(size) > usage.Limits.MaxStorageBytes {
+		return errStorageFull
+	}
+	return nil
+}
+
+// Marks the job cancelled if its message was deleted. The progress message is
+// left as is, the deletion cleanup posts its own notice.
+func cancelled(ctx context.Context, job *Job) bool {
+	n, err := db.RedisClient.Exists(ctx, cancelledKey(job.MessageID)).Result()
+	if err != nil || n == 0 {
+		return false
+	}
+	job.Status = StatusCancelled
+	if err := save(ctx, job); err != nil {
+		log.Printf("Error saving job %s: %v", job.ID, err)
+	}
+	log.Printf("Skipped ingestion job %s, its message was deleted", job.ID)
+	return true
+}
+
+// Moves retries whose backoff has passed back onto the queue
+func scheduleDelayed() {
+	ctx := context.Background()
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids, err := db.RedisClient.ZRangeByScore(ctx, DELAYED_KEY, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(time.Now().Unix(), 10),
+		}).Result()
+		if err != nil {
+			log.Printf("Error reading delayed jobs: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			// Only the caller that removes it requeues it
+			if removed, _ := db.RedisClient.ZRem(ctx, DELAYED_KEY, id).Result(); removed == 1 {
+				db.RedisClient.LPush(ctx, QUEUE_KEY, id)
+			}
+		}
+	}
+}
+
+func setStatus(ctx context.Context, s *discordgo.Session, job *Job, status string, progress string) {
+	job.Status = status
+	if err := save(ctx, job); err != nil {
+		log.Printf("Error saving job %s: %v", job.ID, err)
+	}
+	updateProgress(s, *job, progress)
+}
+
+func save(ctx context.Context, job *Job) error {
+	job.UpdatedAt = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return db.RedisClient.Set(ctx, jobKey(job.ID), data, JOB_TTL).Err()
+}
+
+func updateProgress(s *discordgo.Session, job Job, progress string) {
+	if job.ProgressChannelID == "" || job.ProgressMessageID == "" {
+		return
+	}
+	if _, err := s.ChannelMessageEdit(job.ProgressChannelID, job.ProgressMessageID, progress); err != nil {
+		log.Printf("Error updating progress message for job %s: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,144 @@
+package reindex
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/matthewgaim/intellicord/internal/ai"
+	"github.com/matthewgaim/intellicord/internal/db"
+)
+
+const (
+	BATCH_SIZE      = 20
+	MAX_FAILURES    = 3                // in a row before the run is marked failed
+	STALE_AFTER     = 10 * time.Minute // without a heartbeat, the run is taken over
+	RESUME_INTERVAL = time.Minute
+)
+
+// Identifies this process on the runs it works on
+var worker = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}()
+
+func Worker() string {
+	return worker
+}
+
+var (
+	activeMu sync.Mutex
+	active   = map[int]bool{} // runs this process is working on
+)
+
+// Resumes runs whose worker stopped, like after a restart
+func Start() {
+	go func() {
+		ctx := context.Background()
+		for {
+			runs, err := db.ClaimStaleReindexRuns(ctx, worker, STALE_AFTER)
+			if err != nil {
+				log.Printf("Error claiming re-index runs: %v", err)
+			}
+			for _, run := range runs {
+				log.Printf("Resuming re-index run %d at %d/%d", run.ID, run.Done+run.Skipped, run.Total)
+				go Run(ctx, run, nil)
+			}
+			time.Sleep(RESUME_INTERVAL)
+		}
+	}()
+}
+
+// Starts a run for the server, or every server if serverID is empty, into
+// the current embedding model and chunker. Call Run to work on it.
+func Begin(ctx context.Context, serverID string, startedBy string) (db.ReindexRun, error) {
+	return db.CreateReindexRun(ctx, db.ReindexRun{
+		ServerID:       serverID,
+		EmbeddingModel: ai.EmbeddingModel(),
+		ChunkerVersion: ai.CHUNKER_VERSION,
+		StartedBy:      startedBy,
+	}, worker)
+}
+
+// Re-indexes the run's remaining documents and switches over once they're
+// done. progress, if set, is called after every document.
+func Run(ctx context.Context, run db.ReindexRun, progress func(db.ReindexRun)) error {
+	activeMu.Lock()
+	if active[run.ID] {
+		activeMu.Unlock()
+		return nil
+	}
+	active[run.ID] = true
+	activeMu.Unlock()
+	defer func() {
+		activeMu.Lock()
+		delete(active, run.ID)
+		activeMu.Unlock()
+	}()
+
+	failures := 0
+	for {
+		docs, err := db.GetReindexDocuments(ctx, run, BATCH_SIZE)
+		if err != nil {
+			return fmt.Errorf("failed to list documents: %v", err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+		for _, doc := range docs {
+			skipped, err := ai.ReindexDocument(ctx, run.ID, doc)
+			if err != nil {
+				failures++
+				log.Printf("Error re-indexing document %d (%d/%d): %v", doc.ID, failures, MAX_FAILURES, err)
+				if failures >= MAX_FAILURES {
+					reason := fmt.Sprintf("document %d: %v", doc.ID, err)
+					if stopErr := db.StopReindexRun(ctx, run.ID, db.ReindexFailed, reason); stopErr != nil {
+						log.Printf("Error stopping re-index run %d: %v", run.ID, stopErr)
+					}
+					return fmt.Errorf("re-index run %d failed at %s", run.ID, reason)
+				}
+				time.Sleep(time.Duration(failures) * 10 * time.Second)
+				break // the batch is listed again from the failed document
+			}
+			failures = 0
+
+			run, err = db.AdvanceReindexRun(ctx, run.ID, doc.ID, skipped)
+			if err != nil {
+				return fmt.Errorf("failed to save progress: %v", err)
+			}
+			if run.Status != db.ReindexRunning {
+				// Cancelled while this document was embedding, drop what it wrote
+				return db.StopReindexRun(ctx, run.ID, run.Status, "")
+			}
+			if progress != nil {
+				progress(run)
+			}
+		}
+	}
+
+	if err := db.CompleteReindexRun(ctx, run); err != nil {
+		return err
+	}
+	run.Status = db.ReindexComplete
+	if progress != nil {
+		progress(run)
+	}
+	log.Printf("Re-index run %d complete: %d documents, %d skipped", run.ID, run.Done, run.Skipped)
+	return nil
+}
+
+// Describes the run, like "#4 running: 40/120 documents into text-embedding-3-small, chunker v2"
+func Summary(run db.ReindexRun) string {
+	line := fmt.Sprintf("#%d %s: %d/%d documents", run.ID, run.Status, run.Done+run.Skipped, run.Total)
+	if run.Skipped > 0 {
+		line += fmt.Sprintf(", %d skipped (no stored text and the link expired)", run.Skipped)
+	}
+	line += fmt.Sprintf(" into %s, chunker v%d", run.EmbeddingModel, run.ChunkerVersion)
+	if run.Error != "" {
+		line += "\nError: " + run.Error
+	}
+	return line
+}
@@ -9,23 +9,75 @@ import csv
 app = Flask(__name__)
 valid_file_types = ["pdf", "epub", "txt", "docx", "xlsx", "csv"]
 
-def get_file_type(url: str):
+# Sniffed MIME types the bot sends, to the file types below
+mime_file_types = {
+    "application/pdf": "pdf",
+    "application/epub+zip": "epub",
+    "text/plain": "txt",
+    "application/vnd.openxmlformats-officedocument.wordprocessingml.document": "docx",
+    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": "xlsx",
+    "text/csv": "csv",
+}
+
+def get_file_type(url: str, mime_type=None):
+    """ The sniffed MIME type when there's one, otherwise the URL's extension """
+    if mime_type:
+        return mime_file_types.get(mime_type, "")
     path = url.split("?")[0]
     return path.split(".")[-1].lower()
 
+# Highest extraction response version this API can return.
+# 1: {"extracted_text", "file_size"}
+# 2: adds "version", "page_count" and "segments", each with the page number,
+#    sheet name and heading path of its text. extracted_text is still included.
+LATEST_VERSION = 2
+
+def segment(text, page=None, sheet=None, heading_path=None):
+    return {'page': page, 'sheet': sheet, 'heading_path': heading_path or [], 'text': text}
+
+def toc_heading_path(toc, page_number):
+    """ Heading path of the last table of contents entry starting on or before the page """
+    path = []
+    for level, title, page in toc:
+        if page < 1:
+            continue
+        if page > page_number:
+            break
+        path = path[:level - 1] + [title]
+    return path
+
 def read_with_PyMuPDF(content, file_type):
     """ Supported file types: PDF, EPUB, TXT """
     pdf_document = fitz.open(stream=content, filetype=file_type)
-    extracted_text = "".join(page.get_text() for page in pdf_document)
-    return extracted_text
+    toc = pdf_document.get_toc(simple=True)
+    segments = []
+    for i, page in enumerate(pdf_document):
+        page_number = i + 1
+        segments.append(segment(page.get_text(), page=page_number, heading_path=toc_heading_path(toc, page_number)))
+    return segments, pdf_document.page_count
 
 def read_docx(content):
+    """ One segment per heading, docx files don't know their page breaks """
     document = Document(content)
-    return "\n".join(paragraph.text for paragraph in document.paragraphs)
+    segments = []
+    path = []
+    paragraphs = []
+    for paragraph in document.paragraphs:
+        style = paragraph.style.name if paragraph.style is not None else ""
+        if style.startswith("Heading") and style[len("Heading"):].strip().isdigit():
+            if paragraphs:
+                segments.append(segment("\n".join(paragraphs), heading_path=list(path)))
+            level = int(style[len("Heading"):].strip())
+            path = path[:level - 1] + [paragraph.text.strip()]
+            paragraphs = []
+        paragraphs.append(paragraph.text)
+    if paragraphs:
+        segments.append(segment("\n".join(paragraphs), heading_path=list(path)))
+    return segments, None
 
 def read_xlsx(content):
     workbook = openpyxl.load_workbook(content, data_only=True)
-    extracted_text = []
+    segments = []
     
     for sheet in workbook.sheetnames:
         worksheet = workbook[sheet]
@@ -35,10 +87,12 @@ def read_xlsx(content):
             continue
         
         headers = rows[0]  # First row as headers
+        extracted_text = []
         for row in rows[1:]:  # Iterate over remaining rows
             extracted_text.append("("+",\t".join(f"{headers[i]}: {cell}" if cell is not None else f"{headers[i]}: " for i, cell in enumerate(row))+")")
+        segments.append(segment("\n".join(extracted_text), sheet=sheet))
     
-    return "\n".join(extracted_text)
+    return segments, None
 
 def read_csv(content):
     extracted_text = []
@@ -47,47 +101,71 @@ def read_csv(content):
     
     rows = list(reader)
     if not rows:
-        return ""
+        return [], None
     
     headers = rows[0]  # First row as headers
     for row in rows[1:]:  # Iterate over remaining rows
         extracted_text.append("("+",\t".join(f"{headers[i]}: {cell}" if cell else f"{headers[i]}: " for i, cell in enumerate(row))+")")
     
-    return "\n".join(extracted_text)
+    return [segment("\n".join(extracted_text))], None
 
 
 @app.route('/extract_text', methods=['POST'])
 def extract_text():
     try:
-        data = request.get_json()
-        file_url = data.get('file_url')
-        if not file_url:
-            return jsonify({'error': 'No file URL provided'}), 400
-        
-        file_type = get_file_type(file_url)
-        if file_type not in valid_file_types:
-            return jsonify({'error': 'Unsupported file type'}), 400
-        
-        response = requests.get(file_url, stream=True)
-        response.raise_for_status()
+        # The bot uploads the file it already downloaded, other clients send its URL
+        if request.files.get('file'):
+            data = request.form
+            file_url = request.files['file'].filename
+            file_type = get_file_type(file_url, data.get('mime_type'))
+            if file_type not in valid_file_types:
+                return jsonify({'error': 'Unsupported file type'}), 400
+            raw = request.files['file'].read()
+            file_size = len(raw)
+        else:
+            data = request.get_json()
+            file_url = data.get('file_url')
+            if not file_url:
+                return jsonify({'error': 'No file URL provided'}), 400
+
+            file_type = get_file_type(file_url, data.get('mime_type'))
+            if file_type not in valid_file_types:
+                return jsonify({'error': 'Unsupported file type'}), 400
+
+            response = requests.get(file_url, stream=True)
+            response.raise_for_status()
+            file_size = int(response.headers.get('Content-Length', 0))  # file size in bytes
 
-        file_size = int(response.headers.get('Content-Length', 0))  # file size in bytes
         if (file_size > 50000000): #50mb limit
             return jsonify({'error': 'File too large'}), 413
-        content = BytesIO(response.content)
+        content = BytesIO(raw if request.files.get('file') else response.content)
 
         print(f"Parsed {file_url}, Size: {file_size} bytes")
 
         if file_type == "docx":
-            extracted_text = read_docx(content)
+            segments, page_count = read_docx(content)
         elif file_type == "xlsx":
-            extracted_text = read_xlsx(content)
+            segments, page_count = read_xlsx(content)
         elif file_type == "csv":
-            extracted_text = read_csv(content)
+            segments, page_count = read_csv(content)
         else:
-            extracted_text = read_with_PyMuPDF(content, file_type)
+            segments, page_count = read_with_PyMuPDF(content, file_type)
+
+        # Same text version 1 always returned, pages used to be joined without a separator
+        joiner = "" if file_type in ["pdf", "epub", "txt"] else "\n"
+        extracted_text = joiner.join(s['text'] for s in segments)
 
-        return jsonify({'extracted_text': extracted_text, 'file_size': file_size}), 200
+        # Clients that don't send a version get the original response
+        version = min(int(data.get('version', 1)), LATEST_VERSION)
+        if version < 2:
+            return jsonify({'extracted_text': extracted_text, 'file_size': file_size}), 200
+        return jsonify({
+            'version': version,
+            'extracted_text': extracted_text,
+            'file_size': file_size,
+            'page_count': page_count,
+            'segments': segments,
+        }), 200
     except Exception as e:
         return jsonify({'error': str(e)}), 500
 
@@ -1,15 +1,5 @@
 CREATE EXTENSION IF NOT EXISTS vector;
 
-CREATE TABLE IF NOT EXISTS chunks (
-    id SERIAL PRIMARY KEY,
-    message_id TEXT,
-    discord_server_id TEXT NOT NULL,
-    title TEXT,
-    doc_url TEXT,
-    content TEXT,
-    embedding vector(1536) -- OpenAI embeddings are 1536-dimensional
-);
-
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
     discord_id TEXT UNIQUE NOT NULL,
@@ -29,9 +19,89 @@ CREATE TABLE IF NOT EXISTS joined_servers (
     allowed_channels TEXT[] DEFAULT '{}',
     llm_company TEXT NOT NULL DEFAULT 'openai',
     llm_model TEXT NOT NULL DEFAULT 'gpt-4.1-nano',
+    llm_temperature DOUBLE PRECISION,
+    llm_max_tokens INTEGER,
+    llm_top_p DOUBLE PRECISION,
+    llm_reasoning_effort TEXT,
+    knowledge_mode TEXT NOT NULL DEFAULT 'knowledge_and_general', -- what /ask and mentions answer from
     FOREIGN KEY (owner_id) REFERENCES users(discord_id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS documents (
+    id SERIAL PRIMARY KEY,
+    discord_server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    thread_id TEXT,
+    message_id TEXT NOT NULL, -- chunks are searched by this ID
+    uploader_id TEXT NOT NULL,
+    title TEXT NOT NULL,
+    source_url TEXT NOT NULL,
+    content_hash TEXT, -- sha256 of the extracted text
+    mime_type TEXT,
+    file_size BIGINT NOT NULL DEFAULT 0,
+    page_count INTEGER,
+    status TEXT NOT NULL DEFAULT 'processing', -- processing, ready or failed
+    embedding_model TEXT,
+    tags TEXT[] NOT NULL DEFAULT '{}', -- forum tags of the post it was posted in
+    original_key TEXT, -- blob of the original file, cleared when it expires
+    text_key TEXT, -- blob of the extracted text, for re-chunking
+    chunker_version INTEGER, -- SplitDocument version the chunks were made with
+    job_id TEXT, -- ingestion job that created it, retries reuse the document
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS documents_message_id_idx ON documents (message_id);
+CREATE INDEX IF NOT EXISTS documents_server_id_idx ON documents (discord_server_id);
+CREATE UNIQUE INDEX IF NOT EXISTS documents_job_id_idx ON documents (job_id) WHERE job_id IS NOT NULL;
+
+-- Re-indexing documents into a new embedding model or chunker
+CREATE TABLE IF NOT EXISTS reindex_runs (
+    id SERIAL PRIMARY KEY,
+    discord_server_id TEXT, -- NULL = every server
+    embedding_model TEXT NOT NULL,
+    chunker_version INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'running', -- running, complete, failed or cancelled
+    total INTEGER NOT NULL DEFAULT 0,
+    done INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    last_document_id INTEGER NOT NULL DEFAULT 0, -- documents go in ID order, a resumed run continues after this
+    started_by TEXT NOT NULL,
+    worker TEXT, -- process working on the run, another one takes over when heartbeat_at goes stale
+    heartbeat_at TIMESTAMP,
+    error TEXT,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS chunks (
+    id SERIAL PRIMARY KEY,
+    message_id TEXT,
+    discord_server_id TEXT NOT NULL,
+    title TEXT,
+    doc_url TEXT,
+    content TEXT,
+    embedding vector(1536), -- OpenAI embeddings are 1536-dimensional
+    source_path TEXT, -- path and line range of source code chunks
+    start_line INTEGER,
+    end_line INTEGER,
+    document_id INTEGER REFERENCES documents(id) ON DELETE CASCADE,
+    ordinal INTEGER, -- position in the document
+    page INTEGER,
+    section TEXT, -- heading path, like "Setup > Docker"
+    reindex_run_id INTEGER REFERENCES reindex_runs(id) ON DELETE CASCADE -- set while a re-index run is building it, hidden from search
+);
+-- Original files and extracted text in blob storage, keyed by content hash
+CREATE TABLE IF NOT EXISTS blobs (
+    key TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS chunks_document_id_idx ON chunks (document_id);
+CREATE INDEX IF NOT EXISTS chunks_message_id_idx ON chunks (message_id);
+CREATE INDEX IF NOT EXISTS chunks_reindex_run_id_idx ON chunks (reindex_run_id) WHERE reindex_run_id IS NOT NULL;
+
 CREATE TABLE IF NOT EXISTS uploaded_files (
     id SERIAL PRIMARY KEY,
     discord_server_id TEXT NOT NULL,
@@ -40,9 +110,11 @@ CREATE TABLE IF NOT EXISTS uploaded_files (
     title TEXT NOT NULL,
     file_url TEXT NOT NULL,
     file_size BIGINT NOT NULL,
+    message_id TEXT,
     uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
 );
+CREATE INDEX IF NOT EXISTS uploaded_files_message_id_idx ON uploaded_files (message_id);
 
 CREATE TABLE IF NOT EXISTS message_logs (
     id SERIAL PRIMARY KEY,
@@ -54,6 +126,35 @@ CREATE TABLE IF NOT EXISTS message_logs (
     FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
 );
 
+-- Answers the bot gave in threads, question_message_id is the message the question was read from
+CREATE TABLE IF NOT EXISTS answers (
+    id SERIAL PRIMARY KEY,
+    answer_message_id TEXT NOT NULL, -- last message of the answer, it has the buttons
+    message_ids TEXT[] NOT NULL, -- every message the answer was sent as
+    question_message_id TEXT NOT NULL,
+    question_channel_id TEXT NOT NULL,
+    log_message_id TEXT, -- its entry in message_logs, NULL for /ask
+    asker_id TEXT NOT NULL,
+    discord_server_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    llm_company TEXT NOT NULL,
+    llm_model TEXT NOT NULL,
+    retrieval_limit INTEGER NOT NULL,
+    sources JSONB NOT NULL DEFAULT '[]', -- retrieved chunks as they were when answering
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS answers_answer_message_id_idx ON answers (answer_message_id);
+
+CREATE TABLE IF NOT EXISTS answer_feedback (
+    answer_id INTEGER NOT NULL REFERENCES answers(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    rating SMALLINT NOT NULL, -- 1 or -1
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (answer_id, user_id)
+);
+
 CREATE TABLE IF NOT EXISTS banned_users (
     id SERIAL PRIMARY KEY,
     discord_user_id TEXT NOT NULL,
@@ -61,3 +162,43 @@ CREATE TABLE IF NOT EXISTS banned_users (
     reason TEXT,
     FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
 );
+
+CREATE TABLE IF NOT EXISTS channel_settings (
+    channel_id TEXT PRIMARY KEY,
+    discord_server_id TEXT NOT NULL,
+    llm_company TEXT,
+    llm_model TEXT,
+    persona TEXT,
+    retrieval_depth INTEGER,
+    auto_thread BOOLEAN,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS knowledge_base (
+    document_id INTEGER PRIMARY KEY REFERENCES documents(id) ON DELETE CASCADE,
+    discord_server_id TEXT NOT NULL,
+    added_by TEXT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS knowledge_base_server_id_idx ON knowledge_base (discord_server_id);
+
+-- Channels whose message history is indexed, opted in with /indexchannel
+CREATE TABLE IF NOT EXISTS channel_history (
+    channel_id TEXT PRIMARY KEY,
+    discord_server_id TEXT NOT NULL,
+    document_id INTEGER NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    last_message_id TEXT, -- newest message indexed, NULL until the backfill is done
+    enabled_by TEXT NOT NULL,
+    enabled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS thread_documents (
+    thread_id TEXT NOT NULL,
+    message_id TEXT NOT NULL, -- chunks of the message's documents are stored under this ID
+    discord_server_id TEXT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (thread_id, message_id),
+    FOREIGN KEY (discord_server_id) REFERENCES joined_servers(discord_server_id) ON DELETE CASCADE
+);